@@ -11,21 +11,31 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/adrian-bl/dyslink/lib/dyslink"
 )
 
-var flagHost = flag.String("host", "10.0.42.137:1883", "The ip:port combination to connect to")
+var flagHost = flag.String("host", "", "The ip:port combination to connect to, see -discover")
+var flagDiscover = flag.Bool("discover", false, "Find the fan with given serial via mDNS if no -host is given (takes up to 5 seconds)")
 var flagUser = flag.String("user", "", "The user to use. Part of setup SSID, example: NN4-CH-HEA0322B")
-var flagPass = flag.String("password", "", "The passwort to use. See sticker on the manual (or under your fans filter)")
+var flagSsid = flag.String("ssid", "", "The setup SSID of the fan, example: DYSON-NN4-CH-HEA0322B-475. Sets -user and -model")
+var flagPass = flag.String("password", "", "The passwort to use. See sticker on the manual (or under your fans filter). Visible to other users, prefer -credentials or $DYSLINK_PASSWORD")
+var flagCredentials = flag.String("credentials", "", "Read serial and password from this file, defaults to $DYSLINK_CREDENTIALS")
+var flagSaveCredentials = flag.String("save-credentials", "", "Write the credentials received via -bootstrap to this file")
 var flagBootstrap = flag.Bool("bootstrap", false, "Bootstrap a factory reseted filter, requires -boot-essid and -boot-password")
 var flagBootEssid = flag.String("boot-essid", "i-did-not-read-the-manual", "The essid the fan should join to (while using -bootstrap)")
 var flagBootPass = flag.String("boot-password", "", "The password of the wifi network specified via -boot-essid")
+var flagBootDryRun = flag.Bool("boot-dry-run", false, "Only fetch the credentials while using -bootstrap, the fan does not join any network")
 var flagHelp = flag.Bool("help", false, "Print what you are currently reading")
 var flagHangAround = flag.Bool("hang", false, "Keep running after sending a command to get status updates of the fan")
+var flagConfirm = flag.Bool("confirm", false, "Wait until the fan confirmed the new state")
+var flagStatus = flag.Bool("status", false, "Print the current state and sensor data of the fan instead of setting a state")
 
 var flagStateSleep = flag.String("sleep-timer", "", "Sleep timer in minutes, eg: '5'. Passing '0' cancels the timer.")
 var flagStateFanSpeed = flag.String("fan-speed", "", "Set fan to this speed (1-10). 0 turns the fan off, -1 uses auto mode.")
@@ -33,8 +43,9 @@ var flagStateOscillate = flag.Bool("oscillate", false, "Enable or disable oscill
 var flagStateNight = flag.Bool("night-mode", false, "Enable or disable night mode")
 var flagHighQuality = flag.Bool("high-quality", false, "Target 'high air quality'")
 
-// MODEL is hardcoded for now.
-const MODEL = dyslink.TypeModelN475
+var flagVerbose = flag.Bool("verbose", false, "Log the traffic between client and fan to stderr")
+var flagRecord = flag.String("record", "", "Append all messages sent and received to this capture file (jsonl), useful for bug reports")
+var flagModel = flag.String("model", "", "The product type of the fan, eg. 475 or 438E (default: from credentials or 475)")
 
 func main() {
 	flag.Parse()
@@ -43,45 +54,88 @@ func main() {
 		os.Exit(1)
 	}
 
-	cb := make(chan *dyslink.MessageCallback)
-	opts := &dyslink.ClientOpts{
-		Model:         MODEL,
-		Username:      *flagUser,
-		Password:      *flagPass,
-		DeviceAddress: fmt.Sprintf("tcp://%s", *flagHost),
-		CallbackChan:  cb,
+	// buffered: messages are only consumed with -hang, but we
+	// must not stall the client while waiting for a confirmation.
+	cb := make(chan *dyslink.MessageCallback, 64)
+	opts, err := dyslink.ResolveOpts(&dyslink.ResolveOptions{
+		CredentialsPath: *flagCredentials,
+		Username:        *flagUser,
+		Password:        *flagPass,
+		Ssid:            *flagSsid,
+		Model:           *flagModel,
+		Host:            *flagHost,
+		Discover:        *flagDiscover,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve the fan: %s\n", err)
+		os.Exit(1)
+	}
+	opts.CallbackChan = cb
+	if _, ok := dyslink.LookupModel(opts.Model); !ok {
+		fmt.Fprintf(os.Stderr, "Warning: unknown model '%s', not checking its capabilities. Known models: %v\n", opts.Model, dyslink.KnownModels())
+	}
+	if *flagVerbose == true {
+		opts.Logger = dyslink.NewWriterLogger(os.Stderr, dyslink.LevelDebug)
+	}
+	if *flagRecord != "" {
+		rec, err := dyslink.CreateRecorder(*flagRecord)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open capture file: %s\n", err)
+			os.Exit(1)
+		}
+		defer rec.Close()
+		opts.Recorder = rec
 	}
 	c := dyslink.NewClient(opts)
-	err := c.Connect()
+	err = c.Connect()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect to '%s' as '%s', error: %s\n", *flagHost, *flagUser, err)
+		fmt.Fprintf(os.Stderr, "Failed to connect to '%s' as '%s', error: %s\n", opts.DeviceAddress, opts.Username, err)
 		os.Exit(2)
 	}
 
 	if *flagBootstrap == true {
-		*flagHangAround = true
-		fmt.Printf("Bootstrapping %s into wifi network %s\n", *flagHost, *flagBootEssid)
-		c.WifiBootstrap(*flagBootEssid, *flagBootPass)
-		// note that this is untested in the client - but the dyslink itself works.
-	} else {
-		mode := dyslink.FanModeOn
-		if *flagStateFanSpeed == "0" {
-			mode = dyslink.FanModeOff
+		fmt.Printf("Bootstrapping %s into wifi network %s\n", opts.DeviceAddress, *flagBootEssid)
+		res, err := c.Provision(context.Background(), &dyslink.ProvisionOpts{
+			Ssid:     *flagBootEssid,
+			Password: *flagBootPass,
+			DryRun:   *flagBootDryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bootstrap failed: %s\n", err)
+			os.Exit(3)
 		}
-		if *flagStateFanSpeed == "-1" {
-			mode = dyslink.FanModeAuto
+		fmt.Printf("Bootstrapped %s, password hash: %s\n", res.Serial, res.PasswordHash)
+		if *flagSaveCredentials != "" {
+			if err := dyslink.SaveCredentials(*flagSaveCredentials, res.Credentials(opts.Model)); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to save credentials: %s\n", err)
+				os.Exit(3)
+			}
+			fmt.Printf("Saved credentials to %s\n", *flagSaveCredentials)
 		}
-
-		state := &dyslink.FanState{
-			FanMode:       mode,
-			Oscillate:     triGet("oscillate", "", dyslink.OscillateOn, dyslink.OscillateOff),
-			FanSpeed:      *flagStateFanSpeed,
-			NightMode:     triGet("night-mode", "", dyslink.NightModeOn, dyslink.NightModeOff),
-			SleepTimer:    *flagStateSleep,
-			QualityTarget: triGet("high-quality", "", dyslink.QualityHigh, dyslink.QualityLow),
+	} else if *flagStatus == true {
+		ps, env, err := c.GetCurrent(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get state: %s\n", err)
+			os.Exit(3)
+		}
+		fmt.Printf("State: %+v\nEnvironment: %+v\n", ps, env)
+	} else {
+		state, err := buildState(opts.Model)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid state: %s\n", err)
+			os.Exit(1)
+		}
+		if *flagConfirm == true {
+			ps, err := c.SetStateConfirmed(context.Background(), state)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to set state: %s\n", err)
+				os.Exit(3)
+			}
+			fmt.Printf("Confirmed: %+v\n", ps)
+		} else {
+			c.SetState(state)
+			fmt.Printf("Set: %#v\n", state)
 		}
-		c.SetState(state)
-		fmt.Printf("Set: %#v\n", state)
 	}
 
 	if *flagHangAround == true {
@@ -89,24 +143,63 @@ func main() {
 		for {
 			v := <-cb
 			fmt.Printf("Message: %+v\n", v.Message)
+			if env, ok := v.Message.(*dyslink.EnvironmentState); ok {
+				if aq, err := dyslink.ComputeAirQuality(env); err == nil {
+					fmt.Printf("Air quality: %s\n", aq)
+				}
+			}
 		}
 	}
 }
 
-func triGet(flagName, isUndef, isTrue, isFalse string) string {
-	// Used to check if the flag was passed or if a default should be used
-	passedFlags := make(map[string]bool)
-	flag.Visit(func(f *flag.Flag) { passedFlags[f.Name] = true })
-
-	ok := passedFlags[flagName]
-
-	if ok == false {
-		return isUndef
+// buildState assembles the state to send from the passed flags
+func buildState(model string) (*dyslink.FanState, error) {
+	b := dyslink.NewStateBuilder()
+	b.Mode(dyslink.FanModeOn)
+	if *flagStateFanSpeed != "" {
+		speed, err := strconv.Atoi(*flagStateFanSpeed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fan speed %q", *flagStateFanSpeed)
+		}
+		switch speed {
+		case 0:
+			b.Mode(dyslink.FanModeOff)
+		case -1:
+			b.Mode(dyslink.FanModeAuto)
+		default:
+			b.Speed(speed)
+		}
 	}
-
-	val := flag.Lookup(flagName).Value.(flag.Getter).Get().(bool)
-	if val == true {
-		return isTrue
+	if *flagStateSleep != "" {
+		minutes, err := strconv.Atoi(*flagStateSleep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sleep timer %q", *flagStateSleep)
+		}
+		b.SleepTimer(time.Duration(minutes) * time.Minute)
+	}
+	if flagPassed("oscillate") {
+		b.Oscillate(*flagStateOscillate)
 	}
-	return isFalse
+	if flagPassed("night-mode") {
+		b.NightMode(*flagStateNight)
+	}
+	if flagPassed("high-quality") {
+		if *flagHighQuality == true {
+			b.QualityTarget(dyslink.QualityHigh)
+		} else {
+			b.QualityTarget(dyslink.QualityLow)
+		}
+	}
+	return b.Build(model)
+}
+
+// flagPassed returns true if the flag was passed on the command line
+func flagPassed(flagName string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == flagName {
+			passed = true
+		}
+	})
+	return passed
 }
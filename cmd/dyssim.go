@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2017 Adrian Ulrich <adrian@blinkenlights.ch>
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+	"github.com/adrian-bl/dyslink/lib/dyslink/sim"
+)
+
+var (
+	flagListen        = flag.String("listen", "127.0.0.1:1883", "ip:port the simulated fan listens on")
+	flagModel         = flag.String("model", dyslink.TypeModelN475, "The product type to simulate, eg. 475 or 438E")
+	flagSerial        = flag.String("serial", sim.DefaultSerial, "The serial (and username) of the simulated fan")
+	flagPass          = flag.String("password", sim.DefaultPassword, "The password of the simulated fan, as printed on the sticker")
+	flagEnvInterval   = flag.Duration("env-interval", sim.DefaultEnvInterval, "Interval of the sensor data messages")
+	flagUnprovisioned = flag.Bool("unprovisioned", false, "Behave like a factory reset fan, waiting to be bootstrapped")
+	flagWifiPass      = flag.String("wifi-password", "", "Reject bootstrapping unless this wifi password is used")
+	flagAdvertise     = flag.Bool("advertise", false, "Announce the fan via mDNS")
+	flagCredentials   = flag.String("save-credentials", "", "Write the credentials of the simulated fan to this file")
+	flagVerbose       = flag.Bool("verbose", false, "Log the traffic between clients and the simulated fan")
+)
+
+func main() {
+	flag.Parse()
+	if _, ok := dyslink.LookupModel(*flagModel); !ok {
+		log.Fatalf("unknown model '%s', known models: %v", *flagModel, dyslink.KnownModels())
+	}
+
+	level := dyslink.LevelInfo
+	if *flagVerbose {
+		level = dyslink.LevelDebug
+	}
+	fan := sim.NewFan(&sim.Opts{
+		Model:         *flagModel,
+		Serial:        *flagSerial,
+		Password:      *flagPass,
+		EnvInterval:   *flagEnvInterval,
+		Unprovisioned: *flagUnprovisioned,
+		WifiPassword:  *flagWifiPass,
+		Logger:        dyslink.NewWriterLogger(log.Writer(), level),
+	})
+	if *flagCredentials != "" {
+		if err := dyslink.SaveCredentials(*flagCredentials, fan.Credentials()); err != nil {
+			log.Fatalf("failed to save credentials: %v", err)
+		}
+	}
+
+	l, err := net.Listen("tcp", *flagListen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", *flagListen, err)
+	}
+	log.Printf("simulating a %s with serial %s on %s, password hash: %s", fan.Model(), fan.Serial(), l.Addr(), fan.PasswordHash())
+
+	ctx := context.Background()
+	if *flagAdvertise {
+		go func() {
+			if err := fan.Advertise(ctx, &sim.AdvertiseOpts{Address: advertiseAddress(l.Addr().(*net.TCPAddr))}); err != nil {
+				log.Printf("mDNS responder failed: %v", err)
+			}
+		}()
+	}
+	if err := fan.Serve(ctx, l); err != nil {
+		log.Fatalf("serve failed: %v", err)
+	}
+}
+
+// advertiseAddress returns the address to announce: listening on
+// all interfaces announces the first non-loopback address.
+func advertiseAddress(addr *net.TCPAddr) *net.TCPAddr {
+	if !addr.IP.IsUnspecified() {
+		return addr
+	}
+	ifaddrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, a := range ifaddrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				return &net.TCPAddr{IP: ipnet.IP, Port: addr.Port}
+			}
+		}
+	}
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port}
+}
@@ -17,48 +17,87 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/adrian-bl/dyslink/lib/dyslink"
 )
 
 var (
-	flagHost   = flag.String("host", "10.0.42.137:1883", "The ip:port combination to connect to")
-	flagUser   = flag.String("user", "", "The user to use. Part of setup SSID, example: NN4-CH-HEA0322B")
-	flagPass   = flag.String("password", "", "The passwort to use. See sticker on the manual (or under your fans filter)")
-	flagListen = flag.String("listen", "127.0.0.1:9033", "ip:port to listen on")
+	flagHost        = flag.String("host", "", "The ip:port combination to connect to, see -discover")
+	flagDiscover    = flag.Bool("discover", false, "Find the fan with given serial via mDNS if no -host is given (takes up to 5 seconds)")
+	flagUser        = flag.String("user", "", "The user to use. Part of setup SSID, example: NN4-CH-HEA0322B")
+	flagSsid        = flag.String("ssid", "", "The setup SSID of the fan, example: DYSON-NN4-CH-HEA0322B-475. Sets -user and -model")
+	flagPass        = flag.String("password", "", "The passwort to use. See sticker on the manual (or under your fans filter). Visible to other users, prefer -credentials or $DYSLINK_PASSWORD")
+	flagCredentials = flag.String("credentials", "", "Read serial and password from this file, defaults to $DYSLINK_CREDENTIALS")
+	flagListen      = flag.String("listen", "127.0.0.1:9033", "ip:port to listen on")
+	flagVerbose     = flag.Bool("verbose", false, "Log the traffic between client and fan")
+	flagRecord      = flag.String("record", "", "Append all messages sent and received to this capture file (jsonl), useful for bug reports")
+	flagModel       = flag.String("model", "", "The product type of the fan, eg. 475 or 438E (default: from credentials or 475)")
 )
 
+// commandTimeout is the time we wait for the fan to accept a command
+// before giving up on a http request.
+const commandTimeout = 5 * time.Second
+
 type FanHandler struct {
 	Client dyslink.Client
+	Model  string
 	Status *FanStatus
 }
 
 type FanStatus struct {
 	sync.RWMutex
-	Fan dyslink.ProductState     `json:"Fan"`
-	Env dyslink.EnvironmentState `json:"Env"`
+	Fan       dyslink.ProductState       `json:"Fan"`
+	Env       dyslink.EnvironmentState   `json:"Env"`
+	FanValues *dyslink.ProductValues     `json:"FanValues"`
+	EnvValues *dyslink.EnvironmentValues `json:"EnvValues"`
+	Quality   *dyslink.AirQuality        `json:"AirQuality"`
+	Faults    []dyslink.DeviceFault      `json:"Faults"`
 }
 
 func main() {
 	flag.Parse()
 
-	cb := make(chan *dyslink.MessageCallback)
-	opts := &dyslink.ClientOpts{
-		Model:         dyslink.TypeModelN475,
-		Username:      *flagUser,
-		Password:      *flagPass,
-		DeviceAddress: fmt.Sprintf("tcp://%s", *flagHost),
-		CallbackChan:  cb,
+	events := make(chan *dyslink.Event, 64)
+	opts, err := dyslink.ResolveOpts(&dyslink.ResolveOptions{
+		CredentialsPath: *flagCredentials,
+		Username:        *flagUser,
+		Password:        *flagPass,
+		Ssid:            *flagSsid,
+		Model:           *flagModel,
+		Host:            *flagHost,
+		Discover:        *flagDiscover,
+	})
+	if err != nil {
+		log.Fatalf("failed to resolve the fan: %v", err)
+	}
+	opts.EventChan = events
+	opts.Logger = dyslink.NewWriterLogger(log.Writer(), dyslink.LevelInfo)
+	if *flagVerbose {
+		opts.Logger = dyslink.NewWriterLogger(log.Writer(), dyslink.LevelDebug)
+	}
+	if _, ok := dyslink.LookupModel(opts.Model); !ok {
+		log.Printf("warning: unknown model '%s', not checking its capabilities. Known models: %v", opts.Model, dyslink.KnownModels())
 	}
 
+	if *flagRecord != "" {
+		rec, err := dyslink.CreateRecorder(*flagRecord)
+		if err != nil {
+			log.Fatalf("failed to open capture file: %v", err)
+		}
+		defer rec.Close()
+		opts.Recorder = rec
+	}
 	c := dyslink.NewClient(opts)
 	if err := c.Connect(); err != nil {
-		log.Fatalf("failed to connect to '%s': %v", *flagHost, err)
+		log.Fatalf("failed to connect to '%s': %v", opts.DeviceAddress, err)
 	}
 
 	h := &FanHandler{
 		Client: c,
+		Model:  opts.Model,
 		Status: &FanStatus{},
 	}
 	ctx := context.Background()
@@ -67,28 +106,51 @@ func main() {
 			log.Printf("serveHttp err: %v", err)
 		}
 	}()
-	go monitorStatus(ctx, h, cb)
+	go monitorStatus(ctx, h, events)
 	<-ctx.Done()
 }
 
-func monitorStatus(ctx context.Context, h *FanHandler, cb chan *dyslink.MessageCallback) {
+func monitorStatus(ctx context.Context, h *FanHandler, events chan *dyslink.Event) {
 	h.Client.RequestCurrentState()
+	h.Client.RequestFaults()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg := <-cb:
-			if msg.Error == nil {
-				fmt.Printf("> %+v\n", msg)
-				h.Status.Lock()
-				if v, ok := msg.Message.(*dyslink.ProductState); ok {
+		case ev := <-events:
+			if ev.Error != nil {
+				continue
+			}
+			fmt.Printf("> %s from %s: %+v\n", ev.Kind, ev.Device, ev.Payload)
+			h.Status.Lock()
+			switch ev.Kind {
+			case dyslink.EventStateChange:
+				if sc, ok := ev.StateChange(); ok && sc != nil && sc.Current != nil {
+					log.Printf("state change on %s: %s", ev.Device, sc)
+					h.Status.Fan = *sc.Current
+					h.Status.FanValues = sc.Current.Values()
+				}
+			case dyslink.EventCurrentState:
+				if v, ok := ev.ProductState(); ok && v != nil {
 					h.Status.Fan = *v
+					h.Status.FanValues = v.Values()
 				}
-				if v, ok := msg.Message.(*dyslink.EnvironmentState); ok {
+			case dyslink.EventEnvironment:
+				if v, ok := ev.Environment(); ok && v != nil {
 					h.Status.Env = *v
+					h.Status.EnvValues = v.Values()
+					h.Status.Quality, _ = dyslink.ComputeAirQuality(v)
+				}
+			case dyslink.EventFaults:
+				if v, ok := ev.Faults(); ok && v != nil {
+					h.Status.Faults = v.Faults
+				}
+			case dyslink.EventConnection:
+				if v, ok := ev.Connection(); ok && v != nil {
+					log.Printf("connection state changed to %s (err=%v)", v.State, v.Error)
 				}
-				h.Status.Unlock()
 			}
+			h.Status.Unlock()
 		}
 	}
 }
@@ -124,8 +186,11 @@ func (h *FanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case "/getstate.json":
 			h.serveState(w)
 			return
+		case "/capabilities.json":
+			h.serveCapabilities(w)
+			return
 		case "/toggle.json":
-			h.toggleState(w)
+			h.toggleState(w, r)
 			return
 		}
 	}
@@ -140,7 +205,19 @@ func (h *FanHandler) serveState(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(h.Status)
 }
 
-func (h *FanHandler) toggleState(w http.ResponseWriter) {
+// serveCapabilities serves the features supported by the fan as json.
+func (h *FanHandler) serveCapabilities(w http.ResponseWriter) {
+	caps, ok := dyslink.LookupModel(h.Model)
+	if !ok {
+		// show all controls, the fan will ignore what it does not support
+		caps.Heating = true
+		caps.Oscillation = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(caps)
+}
+
+func (h *FanHandler) toggleState(w http.ResponseWriter, r *http.Request) {
 	h.Status.Lock()
 	defer h.Status.Unlock()
 
@@ -153,7 +230,12 @@ func (h *FanHandler) toggleState(w http.ResponseWriter) {
 	if h.Status.Fan.FanMode == dyslink.FanModeOff {
 		state.FanMode = dyslink.FanModeOn
 	}
-	h.Client.SetState(state)
+	ctx, cancel := context.WithTimeout(r.Context(), commandTimeout)
+	defer cancel()
+	if err := h.Client.SetStateContext(ctx, state); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(state)
@@ -161,34 +243,42 @@ func (h *FanHandler) toggleState(w http.ResponseWriter) {
 
 func (h *FanHandler) setState(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	w.WriteHeader(200)
 
-	state := &dyslink.FanState{}
+	b := dyslink.NewStateBuilder()
 	if v := r.Form["mode"]; len(v) == 1 {
-		switch v[0] {
-		case "OFF":
-			state.FanMode = dyslink.FanModeOff
-		case "FAN":
-			state.FanMode = dyslink.FanModeOn
-		case "AUTO":
-			state.FanMode = dyslink.FanModeAuto
-		}
+		b.Mode(v[0])
 	}
 
-	if v := r.Form["speed"]; len(v) == 1 {
-		state.FanSpeed = v[0]
+	if v := r.Form["speed"]; len(v) == 1 && r.Form.Get("mode") != dyslink.FanModeAuto {
+		speed, err := strconv.Atoi(v[0])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid speed %q", v[0]), http.StatusBadRequest)
+			return
+		}
+		b.Speed(speed)
 	}
 
 	if v := r.Form["rotate"]; len(v) == 1 {
-		switch v[0] {
-		case "ON":
-			state.Oscillate = dyslink.OscillateOn
-		case "OFF":
-			state.Oscillate = dyslink.OscillateOff
-		}
+		b.Oscillate(v[0] == "ON")
 	}
 
-	h.Client.SetState(state)
+	if v := r.Form["heat"]; len(v) == 1 {
+		b.Heat(v[0] == dyslink.HeatModeOn)
+	}
+
+	state, err := b.Build(h.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), commandTimeout)
+	defer cancel()
+	if err := h.Client.SetStateContext(ctx, state); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(200)
 }
 
 // serveIndex serves the main html.
@@ -250,33 +340,58 @@ func (h *FanHandler) serveIndex(w http.ResponseWriter) {
 </select>
 <br>
 
+<div class="rotate">
 <div class="title">Rotation</div>
 <select class="select" id="rotate">
   <option value="OFF">Off</option>
   <option value="ON">Rotate</option>
 </select>
+<br>
+</div>
+
+<div class="heat">
+<div class="title">Heating</div>
+<select class="select" id="heat">
+  <option value="OFF">Off</option>
+  <option value="HEAT">Heat</option>
+</select>
+</div>
 </div>
 
 <script>
 
 var busy = 0;
+var caps = {};
 
 function setFan() {
   busy = 1;
+  data = {
+    mode: $("#mode").val(),
+    speed: $("#speed").val(),
+  };
+  if (caps.Oscillation) {
+    data.rotate = $("#rotate").val();
+  }
+  if (caps.Heating) {
+    data.heat = $("#heat").val();
+  }
   $.ajax({
     type: "POST",
     url: "setstate.json",
-    data: {
-      mode: $("#mode").val(),
-      speed: $("#speed").val(),
-      rotate: $("#rotate").val(),
-    },
+    data: data,
   });
 }
 
 $("#mode").change(function()   { setFan(); });
 $("#speed").change(function()  { setFan(); });
 $("#rotate").change(function() { setFan(); });
+$("#heat").change(function()   { setFan(); });
+
+$.getJSON("capabilities.json", function(data) {
+  caps = data;
+  $('.rotate').toggle(caps.Oscillation);
+  $('.heat').toggle(caps.Heating);
+});
 
 (function poll() {
     $.ajax({
@@ -294,11 +409,11 @@ $("#rotate").change(function() { setFan(); });
 })();
 
 function restoreUI(data) {
-  fs = parseInt(data.Fan.FanSpeed)
-  if (!isNaN(fs)) {
-    $('#speed').val(fs);
+  if (data.FanValues && data.FanValues.FanSpeed != "AUTO") {
+    $('#speed').val(data.FanValues.FanSpeed);
   }
   $('#rotate').val(data.Fan.Oscillate);
+  $('#heat').val(data.Fan.HeatMode);
   $('#mode').val(data.Fan.FanMode);
   $('#ui').css("visibility", "visible");
   busy = 0;
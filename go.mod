@@ -5,5 +5,5 @@ go 1.13
 require (
 	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/mitchellh/mapstructure v1.1.2
-	golang.org/x/net v0.0.0-20191126235420-ef20fe5d7933 // indirect
+	golang.org/x/net v0.0.0-20191126235420-ef20fe5d7933
 )
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"fmt"
+	"math"
+)
+
+// Pollutants reported by AirQuality
+const (
+	PollutantPM25      = "PM2.5"
+	PollutantPM10      = "PM10"
+	PollutantVOC       = "VOC"
+	PollutantNO2       = "NO2"
+	PollutantParticles = "PARTICLES" // particle index of older devices (pact)
+)
+
+// AirQuality holds air quality indices computed from an EnvironmentState.
+// Each index names the pollutant which drives its score.
+type AirQuality struct {
+	EPA            int    // US EPA AQI, 0-500
+	EPAPollutant   string // empty if no particulate reading was available
+	CAQI           int    // EU common air quality index, 0-100 (and above)
+	CAQIPollutant  string // empty if no particulate reading was available
+	DysonBand      int    // 1 (good) to 10 (severe), as shown by the Dyson app
+	DysonPollutant string
+}
+
+func (aq *AirQuality) String() string {
+	return fmt.Sprintf("EPA=%d (%s) CAQI=%d (%s) Dyson=%d (%s)",
+		aq.EPA, aq.EPAPollutant, aq.CAQI, aq.CAQIPollutant, aq.DysonBand, aq.DysonPollutant)
+}
+
+// breakpoint maps the concentration range [clo, chi] to the index range [ilo, ihi]
+type breakpoint struct {
+	clo, chi float64
+	ilo, ihi float64
+}
+
+// US EPA breakpoints (2024 revision), in ug/m3
+var (
+	epaPM25 = []breakpoint{
+		{0, 9.0, 0, 50}, {9.1, 35.4, 51, 100}, {35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200}, {125.5, 225.4, 201, 300}, {225.5, 325.4, 301, 500},
+	}
+	epaPM10 = []breakpoint{
+		{0, 54, 0, 50}, {55, 154, 51, 100}, {155, 254, 101, 150},
+		{255, 354, 151, 200}, {355, 424, 201, 300}, {425, 604, 301, 500},
+	}
+)
+
+// EU CAQI hourly grid, in ug/m3. Values above the grid are extrapolated.
+var (
+	caqiPM25 = []breakpoint{{0, 15, 0, 25}, {15, 30, 25, 50}, {30, 55, 50, 75}, {55, 110, 75, 100}}
+	caqiPM10 = []breakpoint{{0, 25, 0, 25}, {25, 50, 25, 50}, {50, 90, 50, 75}, {90, 180, 75, 100}}
+)
+
+// Upper limits of the Dyson bands 1-9 in ug/m3, anything above is band 10
+var (
+	dysonPM25 = []float64{11, 23, 35, 41, 47, 53, 61, 70, 150}
+	dysonPM10 = []float64{16, 33, 50, 58, 66, 75, 83, 100, 350}
+)
+
+// ComputeAirQuality computes the air quality indices for given environment data.
+// An error is returned if the state holds no usable pollutant readings.
+func ComputeAirQuality(e *EnvironmentState) (*AirQuality, error) {
+	v := e.Values()
+	aq := &AirQuality{}
+
+	pm25 := v.PM25Revised
+	if !pm25.Valid() {
+		pm25 = v.PM25
+	}
+	pm10 := v.PM10Revised
+	if !pm10.Valid() {
+		pm10 = v.PM10
+	}
+
+	if pm25.Valid() {
+		pickIndex(&aq.EPA, &aq.EPAPollutant, scaleIndex(epaPM25, math.Floor(pm25.Value*10)/10, false), PollutantPM25)
+		pickIndex(&aq.CAQI, &aq.CAQIPollutant, scaleIndex(caqiPM25, pm25.Value, true), PollutantPM25)
+		pickIndex(&aq.DysonBand, &aq.DysonPollutant, dysonBand(dysonPM25, pm25.Value), PollutantPM25)
+	}
+	if pm10.Valid() {
+		pickIndex(&aq.EPA, &aq.EPAPollutant, scaleIndex(epaPM10, math.Floor(pm10.Value), false), PollutantPM10)
+		pickIndex(&aq.CAQI, &aq.CAQIPollutant, scaleIndex(caqiPM10, pm10.Value, true), PollutantPM10)
+		pickIndex(&aq.DysonBand, &aq.DysonPollutant, dysonBand(dysonPM10, pm10.Value), PollutantPM10)
+	}
+	// VOC and NO2 are only reported as an index by the device, so they
+	// can't be mapped to EPA or CAQI values.
+	if v.VOCIndex.Valid() {
+		pickIndex(&aq.DysonBand, &aq.DysonPollutant, indexBand(v.VOCIndex.Value), PollutantVOC)
+	}
+	if v.NO2Index.Valid() {
+		pickIndex(&aq.DysonBand, &aq.DysonPollutant, indexBand(v.NO2Index.Value), PollutantNO2)
+	}
+	if aq.DysonPollutant == "" && e.Particle != "" && e.Particle != "INIT" {
+		// older devices only report indices from 0-9
+		pickIndex(&aq.DysonBand, &aq.DysonPollutant, indexBand(float64(v.Particle)+1), PollutantParticles)
+		if e.UnknownVact != "" && e.UnknownVact != "INIT" {
+			pickIndex(&aq.DysonBand, &aq.DysonPollutant, indexBand(float64(v.VOC)+1), PollutantVOC)
+		}
+	}
+
+	if aq.DysonPollutant == "" {
+		return nil, fmt.Errorf("no pollutant readings available")
+	}
+	return aq, nil
+}
+
+// pickIndex replaces the index and pollutant if the new value is worse
+func pickIndex(index *int, pollutant *string, value int, name string) {
+	if *pollutant == "" || value > *index {
+		*index = value
+		*pollutant = name
+	}
+}
+
+// scaleIndex linearly interpolates the index of concentration c.
+// Concentrations above the last breakpoint are capped unless extrapolate is set.
+func scaleIndex(bps []breakpoint, c float64, extrapolate bool) int {
+	for _, bp := range bps {
+		if c <= bp.chi {
+			return round((bp.ihi-bp.ilo)/(bp.chi-bp.clo)*(math.Max(c, bp.clo)-bp.clo) + bp.ilo)
+		}
+	}
+	last := bps[len(bps)-1]
+	if extrapolate {
+		return round((last.ihi-last.ilo)/(last.chi-last.clo)*(c-last.clo) + last.ilo)
+	}
+	return int(last.ihi)
+}
+
+// dysonBand returns the band (1-10) of concentration c
+func dysonBand(limits []float64, c float64) int {
+	for i, l := range limits {
+		if c <= l {
+			return i + 1
+		}
+	}
+	return len(limits) + 1
+}
+
+// indexBand maps a device index to a band between 1 and 10
+func indexBand(index float64) int {
+	band := int(math.Ceil(index))
+	if band < 1 {
+		band = 1
+	}
+	if band > 10 {
+		band = 10
+	}
+	return band
+}
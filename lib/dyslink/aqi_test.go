@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"testing"
+)
+
+func TestComputeAirQuality(t *testing.T) {
+	tests := []struct {
+		name string
+		env  EnvironmentState
+		want AirQuality
+		err  bool
+	}{
+		{
+			name: "pm2.5 drives all indices",
+			env:  EnvironmentState{PM25: "0012", PM10: "0020"},
+			want: AirQuality{EPA: 56, EPAPollutant: PollutantPM25, CAQI: 20, CAQIPollutant: PollutantPM25, DysonBand: 2, DysonPollutant: PollutantPM25},
+		},
+		{
+			name: "pm10 drives all indices",
+			env:  EnvironmentState{PM25: "0005", PM10: "0100"},
+			want: AirQuality{EPA: 73, EPAPollutant: PollutantPM10, CAQI: 78, CAQIPollutant: PollutantPM10, DysonBand: 8, DysonPollutant: PollutantPM10},
+		},
+		{
+			name: "revised readings are preferred",
+			env:  EnvironmentState{PM25: "0100", PM25Revised: "0005"},
+			want: AirQuality{EPA: 28, EPAPollutant: PollutantPM25, CAQI: 8, CAQIPollutant: PollutantPM25, DysonBand: 1, DysonPollutant: PollutantPM25},
+		},
+		{
+			name: "initializing revised readings are ignored",
+			env:  EnvironmentState{PM25: "0005", PM25Revised: "INIT"},
+			want: AirQuality{EPA: 28, EPAPollutant: PollutantPM25, CAQI: 8, CAQIPollutant: PollutantPM25, DysonBand: 1, DysonPollutant: PollutantPM25},
+		},
+		{
+			name: "voc only affects the dyson band",
+			env:  EnvironmentState{PM25: "0005", VOCIndex: "0070"},
+			want: AirQuality{EPA: 28, EPAPollutant: PollutantPM25, CAQI: 8, CAQIPollutant: PollutantPM25, DysonBand: 7, DysonPollutant: PollutantVOC},
+		},
+		{
+			name: "no2 only affects the dyson band",
+			env:  EnvironmentState{PM25: "0005", NO2Index: "0031"},
+			want: AirQuality{EPA: 28, EPAPollutant: PollutantPM25, CAQI: 8, CAQIPollutant: PollutantPM25, DysonBand: 4, DysonPollutant: PollutantNO2},
+		},
+		{
+			name: "caqi is extrapolated",
+			env:  EnvironmentState{PM25: "0220"},
+			want: AirQuality{EPA: 295, EPAPollutant: PollutantPM25, CAQI: 150, CAQIPollutant: PollutantPM25, DysonBand: 10, DysonPollutant: PollutantPM25},
+		},
+		{
+			name: "epa is capped",
+			env:  EnvironmentState{PM25: "0500"},
+			want: AirQuality{EPA: 500, EPAPollutant: PollutantPM25, CAQI: 277, CAQIPollutant: PollutantPM25, DysonBand: 10, DysonPollutant: PollutantPM25},
+		},
+		{
+			name: "older device indices",
+			env:  EnvironmentState{Particle: "0003", UnknownVact: "0005"},
+			want: AirQuality{DysonBand: 6, DysonPollutant: PollutantVOC},
+		},
+		{
+			name: "older device without voc",
+			env:  EnvironmentState{Particle: "0002", UnknownVact: "INIT"},
+			want: AirQuality{DysonBand: 3, DysonPollutant: PollutantParticles},
+		},
+		{
+			name: "sensors initializing",
+			env:  EnvironmentState{PM25: "INIT", PM10: "INIT", Particle: "INIT"},
+			err:  true,
+		},
+		{
+			name: "no readings",
+			env:  EnvironmentState{Temperature: "2950"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ComputeAirQuality(&tt.env)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ComputeAirQuality() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ComputeAirQuality() failed: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("ComputeAirQuality() = %v, want %v", got, &tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"fmt"
+	"time"
+)
+
+// Units accepted by StateBuilder.HeatTarget
+type TemperatureUnit int
+
+const (
+	Kelvin TemperatureUnit = iota
+	Celsius
+	Fahrenheit
+)
+
+// Limits of the values accepted by the device
+const (
+	MinFanSpeed      = 1
+	MaxFanSpeed      = 10
+	MaxSleepTimer    = 9 * time.Hour
+	MinHeatTarget    = Temperature(2740) // ~1 degree celsius
+	MaxHeatTarget    = Temperature(3100) // ~37 degree celsius
+	MaxOscillation   = 355               // upper oscillation angle limit
+	valueResetFilter = "RSTF"
+)
+
+// StateBuilder assembles a FanState and validates all values
+// before they are sent to the device.
+// The first invalid value is remembered and returned by Build.
+// The capabilities of the model are only checked for models known
+// to LookupModel: states for other models are built unchecked.
+type StateBuilder struct {
+	state FanState
+	err   error
+}
+
+// NewStateBuilder returns an empty StateBuilder
+func NewStateBuilder() *StateBuilder {
+	return &StateBuilder{}
+}
+
+// fail records the first error encountered
+func (b *StateBuilder) fail(format string, args ...interface{}) *StateBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf(format, args...)
+	}
+	return b
+}
+
+// Mode sets the fan mode to one of the FanMode* constants
+func (b *StateBuilder) Mode(mode string) *StateBuilder {
+	switch mode {
+	case FanModeOff, FanModeOn, FanModeAuto:
+		b.state.FanMode = mode
+		return b
+	}
+	return b.fail("invalid fan mode %q", mode)
+}
+
+// Power turns the fan on or off
+func (b *StateBuilder) Power(on bool) *StateBuilder {
+	if on {
+		return b.Mode(FanModeOn)
+	}
+	return b.Mode(FanModeOff)
+}
+
+// Speed sets a fixed fan speed between MinFanSpeed and MaxFanSpeed
+func (b *StateBuilder) Speed(speed int) *StateBuilder {
+	if speed < MinFanSpeed || speed > MaxFanSpeed {
+		return b.fail("fan speed %d out of range (%d-%d)", speed, MinFanSpeed, MaxFanSpeed)
+	}
+	b.state.FanSpeed = fmt.Sprintf("%04d", speed)
+	return b
+}
+
+// AutoSpeed lets the device pick the fan speed
+func (b *StateBuilder) AutoSpeed() *StateBuilder {
+	b.state.FanSpeed = FanSpeedAuto
+	return b
+}
+
+// Oscillate enables or disables oscillation
+func (b *StateBuilder) Oscillate(on bool) *StateBuilder {
+	b.state.Oscillate = onOff(on, OscillateOn, OscillateOff)
+	return b
+}
+
+// NightMode enables or disables the night mode
+func (b *StateBuilder) NightMode(on bool) *StateBuilder {
+	b.state.NightMode = onOff(on, NightModeOn, NightModeOff)
+	return b
+}
+
+// StandbyMonitoring enables or disables capturing of environment data while the fan is off
+func (b *StateBuilder) StandbyMonitoring(on bool) *StateBuilder {
+	b.state.StandbyMonitoring = onOff(on, StandbyMonitorOn, StandbyMonitorOff)
+	return b
+}
+
+// SleepTimer turns the fan off after given duration (rounded to minutes).
+// A zero duration cancels the timer.
+func (b *StateBuilder) SleepTimer(d time.Duration) *StateBuilder {
+	if d == 0 {
+		b.state.SleepTimer = "OFF"
+		return b
+	}
+	if d < time.Minute || d > MaxSleepTimer {
+		return b.fail("sleep timer %s out of range (1m-%s)", d, MaxSleepTimer)
+	}
+	b.state.SleepTimer = fmt.Sprintf("%04d", int(d/time.Minute))
+	return b
+}
+
+// QualityTarget sets the air quality target used in auto mode to one of the Quality* constants
+func (b *StateBuilder) QualityTarget(quality string) *StateBuilder {
+	switch quality {
+	case QualityLow, QualityNormal, QualityHigh:
+		b.state.QualityTarget = quality
+		return b
+	}
+	return b.fail("invalid quality target %q", quality)
+}
+
+// Heat enables or disables heating
+func (b *StateBuilder) Heat(on bool) *StateBuilder {
+	b.state.HeatMode = onOff(on, HeatModeOn, HeatModeOff)
+	return b
+}
+
+// HeatTarget sets the target temperature of the heater
+func (b *StateBuilder) HeatTarget(value float64, unit TemperatureUnit) *StateBuilder {
+	var t Temperature
+	switch unit {
+	case Kelvin:
+		t = Temperature(round(value * 10))
+	case Celsius:
+		t = Temperature(round((value + 273.15) * 10))
+	case Fahrenheit:
+		t = Temperature(ConvertTempFromFahr(round(value)))
+	default:
+		return b.fail("invalid temperature unit %d", unit)
+	}
+	if t < MinHeatTarget || t > MaxHeatTarget {
+		return b.fail("heat target %.1fC out of range (%.1fC-%.1fC)", t.Celsius(), MinHeatTarget.Celsius(), MaxHeatTarget.Celsius())
+	}
+	b.state.HeatTarget = fmt.Sprintf("%04d", int(t))
+	return b
+}
+
+// FocusedMode enables or disables the focused (non diffused) airflow
+func (b *StateBuilder) FocusedMode(on bool) *StateBuilder {
+	b.state.FocusedMode = onOff(on, FocusedModeOn, FocusedModeOff)
+	return b
+}
+
+// OscillationAngles restricts the oscillation to the range between low and high degree.
+// Build checks that the width of the range (high - low) is one of the
+// OscillationAngles supported by the model.
+func (b *StateBuilder) OscillationAngles(low, high int) *StateBuilder {
+	if low < 0 || high > MaxOscillation || low >= high {
+		return b.fail("invalid oscillation range %d-%d (0-%d)", low, high, MaxOscillation)
+	}
+	b.state.OscillationLow = fmt.Sprintf("%04d", low)
+	b.state.OscillationHigh = fmt.Sprintf("%04d", high)
+	b.state.AngleCombination = AngleCustom
+	return b
+}
+
+// FrontAirflow enables or disables the front airflow (as opposed to backwards airflow)
+func (b *StateBuilder) FrontAirflow(on bool) *StateBuilder {
+	b.state.FrontAirflow = onOff(on, FrontAirflowOn, FrontAirflowOff)
+	return b
+}
+
+// ResetFilter resets the remaining filter lifetime
+func (b *StateBuilder) ResetFilter() *StateBuilder {
+	b.state.ResetFilter = valueResetFilter
+	return b
+}
+
+// Build validates the state for given model (one of the TypeModel* constants)
+// and returns it. Models unknown to LookupModel skip the capability checks.
+func (b *StateBuilder) Build(model string) (*FanState, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	s := b.state
+	if s.FanMode == FanModeAuto && s.FanSpeed != "" && s.FanSpeed != FanSpeedAuto {
+		return nil, fmt.Errorf("a fixed fan speed can not be used in auto mode")
+	}
+	caps, ok := LookupModel(model)
+	if !ok {
+		return &s, nil
+	}
+	if !caps.Heating && (s.HeatMode != "" || s.HeatTarget != "") {
+		return nil, fmt.Errorf("model %s (%s) does not support heating", model, caps.Name)
+	}
+	if !caps.FocusMode && s.FocusedMode != "" {
+		return nil, fmt.Errorf("model %s (%s) does not support focused mode", model, caps.Name)
+	}
+	if !caps.Oscillation && s.Oscillate != "" {
+		return nil, fmt.Errorf("model %s (%s) does not support oscillation", model, caps.Name)
+	}
+	if s.AngleCombination != "" {
+		if len(caps.OscillationAngles) == 0 {
+			return nil, fmt.Errorf("model %s (%s) does not support oscillation angles", model, caps.Name)
+		}
+		if width := parseInt(s.OscillationHigh) - parseInt(s.OscillationLow); !containsInt(caps.OscillationAngles, width) {
+			return nil, fmt.Errorf("model %s (%s) does not support an oscillation angle of %d degree (supported: %v)", model, caps.Name, width, caps.OscillationAngles)
+		}
+	}
+	if !caps.ProtocolV2 && s.FrontAirflow != "" {
+		return nil, fmt.Errorf("model %s (%s) does not support changing the airflow direction", model, caps.Name)
+	}
+	if caps.NightModeLevels == 0 && s.NightMode != "" {
+		return nil, fmt.Errorf("model %s (%s) does not support night mode", model, caps.Name)
+	}
+	return &s, nil
+}
+
+// containsInt returns true if v is in list
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+// onOff returns on or off, depending on v
+func onOff(v bool, on, off string) string {
+	if v {
+		return on
+	}
+	return off
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		build func(*StateBuilder)
+		want  FanState
+		err   bool
+	}{
+		{
+			name:  "speed",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Mode(FanModeOn).Speed(7) },
+			want:  FanState{FanMode: FanModeOn, FanSpeed: "0007"},
+		},
+		{
+			name:  "speed too low",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Speed(0) },
+			err:   true,
+		},
+		{
+			name:  "speed too high",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Speed(11) },
+			err:   true,
+		},
+		{
+			name:  "auto mode with fixed speed",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Mode(FanModeAuto).Speed(3) },
+			err:   true,
+		},
+		{
+			name:  "auto mode with auto speed",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Mode(FanModeAuto).AutoSpeed() },
+			want:  FanState{FanMode: FanModeAuto, FanSpeed: FanSpeedAuto},
+		},
+		{
+			name:  "invalid mode",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Mode("TURBO") },
+			err:   true,
+		},
+		{
+			name:  "first error wins",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Speed(42).Mode("TURBO") },
+			err:   true,
+		},
+		{
+			name:  "sleep timer",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.SleepTimer(90 * time.Minute) },
+			want:  FanState{SleepTimer: "0090"},
+		},
+		{
+			name:  "sleep timer off",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.SleepTimer(0) },
+			want:  FanState{SleepTimer: "OFF"},
+		},
+		{
+			name:  "sleep timer too long",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.SleepTimer(10 * time.Hour) },
+			err:   true,
+		},
+		{
+			name:  "heat target in celsius",
+			model: TypeModelN455,
+			build: func(b *StateBuilder) { b.Heat(true).HeatTarget(21, Celsius) },
+			want:  FanState{HeatMode: HeatModeOn, HeatTarget: "2942"},
+		},
+		{
+			name:  "heat target out of range",
+			model: TypeModelN455,
+			build: func(b *StateBuilder) { b.HeatTarget(60, Celsius) },
+			err:   true,
+		},
+		{
+			name:  "heating without heater",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.Heat(true) },
+			err:   true,
+		},
+		{
+			name:  "oscillation angles",
+			model: TypeModelN438,
+			build: func(b *StateBuilder) { b.OscillationAngles(90, 180) },
+			want:  FanState{OscillationLow: "0090", OscillationHigh: "0180", AngleCombination: AngleCustom},
+		},
+		{
+			name:  "oscillation angles reversed",
+			model: TypeModelN438,
+			build: func(b *StateBuilder) { b.OscillationAngles(180, 90) },
+			err:   true,
+		},
+		{
+			name:  "oscillation angle supported by the desk model",
+			model: TypeModelN520,
+			build: func(b *StateBuilder) { b.OscillationAngles(45, 135) },
+			want:  FanState{OscillationLow: "0045", OscillationHigh: "0135", AngleCombination: AngleCustom},
+		},
+		{
+			name:  "oscillation angle not supported by the desk model",
+			model: TypeModelN520,
+			build: func(b *StateBuilder) { b.OscillationAngles(90, 270) },
+			err:   true,
+		},
+		{
+			name:  "oscillation angle not supported at all",
+			model: TypeModelN438,
+			build: func(b *StateBuilder) { b.OscillationAngles(10, 70) },
+			err:   true,
+		},
+		{
+			name:  "oscillation angles on v1 model",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.OscillationAngles(90, 180) },
+			err:   true,
+		},
+		{
+			name:  "front airflow on v1 model",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.FrontAirflow(false) },
+			err:   true,
+		},
+		{
+			name:  "unknown model skips capability checks",
+			model: "999",
+			build: func(b *StateBuilder) { b.Heat(true).FrontAirflow(false).OscillationAngles(10, 70) },
+			want: FanState{HeatMode: HeatModeOn, FrontAirflow: FrontAirflowOff,
+				OscillationLow: "0010", OscillationHigh: "0070", AngleCombination: AngleCustom},
+		},
+		{
+			name:  "quality target",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.QualityTarget(QualityHigh) },
+			want:  FanState{QualityTarget: QualityHigh},
+		},
+		{
+			name:  "invalid quality target",
+			model: TypeModelN475,
+			build: func(b *StateBuilder) { b.QualityTarget("0009") },
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewStateBuilder()
+			tt.build(b)
+			got, err := b.Build(tt.model)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Build() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() failed: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("Build() = %+v, want %+v", got, &tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"sort"
+)
+
+// Capabilities describes the features supported by a product type
+type Capabilities struct {
+	Model             string   // One of the TypeModel* constants
+	Name              string   // Human readable product name
+	Heating           bool     // Supports HeatMode and HeatTarget
+	FocusMode         bool     // Supports FocusedMode
+	Oscillation       bool     // Supports Oscillate
+	OscillationAngles []int    // Selectable oscillation angles in degree, empty if not configurable
+	Humidification    bool     // Has a humidifier
+	Formaldehyde      bool     // Has a formaldehyde sensor
+	NightModeLevels   int      // Number of fan speeds usable in night mode, 0 if there is no night mode
+	ProtocolV2        bool     // Uses fpwr/auto instead of fmod and reports the v2 fields
+	SerialPrefixes    []string // Verified first parts of the serials, used to derive the model of a plain serial
+}
+
+var wideAngles = []int{45, 90, 180, 350}
+
+// modelCapabilities holds the known product types
+var modelCapabilities = map[string]Capabilities{
+	TypeModelN475:  {Name: "Pure Cool Link Tower", Oscillation: true, NightModeLevels: 4, SerialPrefixes: []string{"NN4"}},
+	TypeModelN469:  {Name: "Pure Cool Link Desk", Oscillation: true, NightModeLevels: 4},
+	TypeModelN455:  {Name: "Pure Hot+Cool Link", Heating: true, FocusMode: true, Oscillation: true, NightModeLevels: 4},
+	TypeModelN438:  {Name: "Pure Cool Tower", Oscillation: true, OscillationAngles: wideAngles, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN520:  {Name: "Pure Cool Desk", Oscillation: true, OscillationAngles: []int{45, 90}, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN527:  {Name: "Pure Hot+Cool", Heating: true, FocusMode: true, Oscillation: true, OscillationAngles: wideAngles, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN358:  {Name: "Pure Humidify+Cool", Oscillation: true, OscillationAngles: wideAngles, Humidification: true, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN438E: {Name: "Purifier Cool", Oscillation: true, OscillationAngles: wideAngles, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN527E: {Name: "Purifier Hot+Cool", Heating: true, FocusMode: true, Oscillation: true, OscillationAngles: wideAngles, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN358E: {Name: "Purifier Humidify+Cool", Oscillation: true, OscillationAngles: wideAngles, Humidification: true, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN438K: {Name: "Purifier Cool Formaldehyde", Oscillation: true, OscillationAngles: wideAngles, Formaldehyde: true, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN527K: {Name: "Purifier Hot+Cool Formaldehyde", Heating: true, FocusMode: true, Oscillation: true, OscillationAngles: wideAngles, Formaldehyde: true, NightModeLevels: 4, ProtocolV2: true},
+	TypeModelN358K: {Name: "Purifier Humidify+Cool Formaldehyde", Oscillation: true, OscillationAngles: wideAngles, Humidification: true, Formaldehyde: true, NightModeLevels: 4, ProtocolV2: true},
+}
+
+// LookupModel returns the capabilities of given product type.
+// ok is false if the model is unknown.
+func LookupModel(model string) (caps Capabilities, ok bool) {
+	caps, ok = modelCapabilities[model]
+	caps.Model = model
+	return caps, ok
+}
+
+// KnownModels returns all product types known to LookupModel
+func KnownModels() []string {
+	models := make([]string, 0, len(modelCapabilities))
+	for m := range modelCapabilities {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
+}
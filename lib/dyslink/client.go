@@ -11,63 +11,70 @@
 package dyslink
 
 import (
+	"context"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/mitchellh/mapstructure"
+	"os"
+	"sync"
 	"time"
 )
 
-func sendMessageCallback(ch chan<- *MessageCallback, msg mqtt.Message, debug bool) {
-	var rv interface{}
-	hdr := &commandHeader{}
-	err := json.Unmarshal(msg.Payload(), &hdr)
-	if debug {
-		fmt.Printf("<< raw: %s\n", msg.Payload())
-	}
-	if err == nil {
-		switch hdr.Command {
-		case MessageEnvSensorData:
-			envstate := &EnvironmentState{}
-			err = mapstructure.Decode(hdr.Data, &envstate)
-			rv = envstate
-		case MessageCurrentState:
-			prodstate := &ProductState{}
-			err = mapstructure.Decode(hdr.ProductState, &prodstate)
-			rv = prodstate
-		case MessageDeviceCredentials:
-			devcred := &DeviceCredentials{}
-			err = json.Unmarshal(msg.Payload(), &devcred)
-			rv = devcred
-		case MessageStateChange:
-			rv, err = parseStateChangePayload(hdr.ProductState)
-		default:
-			fmt.Printf("Warning: Unknown state update: %s, json=%s\n", hdr.Command, msg.Payload())
-		}
-	}
-	if ch != nil {
-		ch <- &MessageCallback{Error: err, Message: rv}
-	}
-}
-
 type Client interface {
 	Connect() error
+	ConnectContext(context.Context) error
 	Disconnect(uint)
 	WifiBootstrap(string, string) error
+	WifiBootstrapContext(context.Context, string, string) error
+	Provision(context.Context, *ProvisionOpts) (*ProvisionResult, error)
 	SetState(*FanState) error
+	SetStateContext(context.Context, *FanState) error
+	ApplyState(context.Context, *StateBuilder) error
 	RequestCurrentState() error
+	RequestCurrentStateContext(context.Context) error
+	SetStateConfirmed(context.Context, *FanState) (*ProductState, error)
+	GetState(context.Context) (*ProductState, error)
+	GetEnvironment(context.Context) (*EnvironmentState, error)
+	GetCurrent(context.Context) (*ProductState, *EnvironmentState, error)
+	RequestFaults() error
+	RequestFaultsContext(context.Context) error
+	OnEvent(EventKind, EventHandler)
+	Dropped() uint64
 }
 
+// ErrNotConnected is returned if a command is sent without an established connection.
+var ErrNotConnected = errors.New("client is not connected")
+
 type client struct {
-	MqttClient mqtt.Client
-	opts       *ClientOpts
+	dropped   uint64    // accessed atomically, first field to keep it 64-bit aligned
+	transport Transport // the connected transport, nil if not connected
+	opts      *ClientOpts
+	mu        sync.Mutex
+	topics    map[string]bool // topics we are subscribed to, restored on reconnect
+	connected bool            // true if we had a connection before
+	waiters   map[*waiter]bool
+	handlers  map[EventKind][]EventHandler
+	sink      func(*Event) // receives all events if set, used by Manager
+	logger    Logger
 }
 
 // Returns a new client
 func NewClient(opts *ClientOpts) Client {
-	c := &client{opts: opts}
+	c := &client{
+		opts:     opts,
+		topics:   make(map[string]bool),
+		waiters:  make(map[*waiter]bool),
+		handlers: make(map[EventKind][]EventHandler),
+		logger:   opts.Logger,
+	}
+	if c.logger == nil {
+		c.logger = nopLogger{}
+		if opts.Debug {
+			c.logger = NewWriterLogger(os.Stderr, LevelDebug)
+		}
+	}
 	return c
 }
 
@@ -80,70 +87,227 @@ func encodePassword(in string) string {
 
 // Establishes a new connection
 func (c *client) Connect() error {
-	mqttOpts := mqtt.NewClientOptions().AddBroker(c.opts.DeviceAddress)
-	mqttOpts.SetUsername(c.opts.Username)
-	mqttOpts.SetPassword(encodePassword(c.opts.Password))
-	mqttOpts.SetDefaultPublishHandler(
-		func(client mqtt.Client, msg mqtt.Message) {
-			sendMessageCallback(c.opts.CallbackChan, msg, c.opts.Debug)
-		})
-	mqttOpts.SetOnConnectHandler(func(mclient mqtt.Client) {
-		mclient.Subscribe(c.getDeviceTopic("status/current"), 0, nil)
-	})
-	mqttClient := mqtt.NewClient(mqttOpts)
-	if token := mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext establishes a new connection, giving up
+// once the context expires.
+func (c *client) ConnectContext(ctx context.Context) error {
+	t := c.opts.Transport
+	if t == nil {
+		t = newPahoTransport(c.opts, c.logger)
+	}
+	// receives the result of the initial subscribe
+	ready := make(chan error, 1)
+	var readyOnce sync.Once
+
+	handlers := &TransportHandlers{
+		Message: func(topic string, payload []byte) {
+			c.log(LevelDebug, fmt.Sprintf("received %s", payload), Fields{FieldTopic: topic, FieldDirection: DirectionIn})
+			c.record(DirectionIn, topic, payload)
+			ev := decodeMessage(payload)
+			if ev.Kind == EventRaw {
+				c.log(LevelWarn, fmt.Sprintf("unknown message %s", payload), Fields{FieldTopic: topic})
+			}
+			c.dispatch(ev)
+		},
+		Connected: func() {
+			c.onConnect(t, func(err error) {
+				readyOnce.Do(func() { ready <- err })
+			})
+		},
+		ConnectionLost: func(err error) {
+			c.log(LevelWarn, fmt.Sprintf("connection lost: %v", err), nil)
+			c.connectionEvent(ConnectionDisconnected, err)
+			c.connectionEvent(ConnectionReconnecting, nil)
+		},
+	}
+
+	c.mu.Lock()
+	c.topics[c.getDeviceTopic("status/current")] = true
+	c.mu.Unlock()
+
+	if err := t.Connect(ctx, handlers); err != nil {
+		return err
+	}
+	select {
+	case err := <-ready:
+		if err != nil {
+			t.Disconnect(0)
+			return err
+		}
+	case <-ctx.Done():
+		t.Disconnect(0)
+		c.mu.Lock()
+		if c.transport == t {
+			c.transport = nil
+		}
+		c.mu.Unlock()
+		return fmt.Errorf("waiting for subscriptions: %w", ctx.Err())
 	}
-	c.MqttClient = mqttClient
 	return nil
 }
 
+// onConnect is called by the transport after each successful (re)connect:
+// It restores all subscriptions and, on a reconnect, asks the device
+// for its current state and faults as we might have missed updates.
+// subscribed is called once the subscriptions are restored, each
+// subscribe gives up after DefaultReplyTimeout. The transport is
+// usable before ConnectionConnected is dispatched.
+func (c *client) onConnect(t Transport, subscribed func(error)) {
+	c.mu.Lock()
+	reconnect := c.connected
+	c.connected = true
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+
+	var serr error
+	for _, topic := range topics {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultReplyTimeout)
+		err := t.Subscribe(ctx, topic)
+		cancel()
+		if err != nil {
+			c.log(LevelWarn, fmt.Sprintf("subscribe failed: %v", err), Fields{FieldTopic: topic})
+			serr = fmt.Errorf("subscribing to %s: %w", topic, err)
+		}
+	}
+	if serr != nil && !reconnect {
+		// ConnectContext gives up on this connection
+		c.mu.Lock()
+		c.connected = false
+		c.mu.Unlock()
+		subscribed(serr)
+		return
+	}
+	c.mu.Lock()
+	c.transport = t
+	c.mu.Unlock()
+	subscribed(serr)
+	c.connectionEvent(ConnectionConnected, nil)
+	if reconnect {
+		c.RequestCurrentState()
+		c.RequestFaults()
+	}
+}
+
+// getTransport returns the connected transport, nil if not connected
+func (c *client) getTransport() Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transport
+}
+
+// subscribe subscribes to given topic and remembers
+// it, so that it can be restored after a reconnect.
+func (c *client) subscribe(ctx context.Context, topic string) error {
+	t := c.getTransport()
+	if t == nil {
+		return ErrNotConnected
+	}
+
+	c.mu.Lock()
+	c.topics[topic] = true
+	c.mu.Unlock()
+
+	return t.Subscribe(ctx, topic)
+}
+
 // Disconnect disconnects the client
 // The quiesce parameter defines how long we are going
 // to wait for the connection tear down
 func (c *client) Disconnect(quiesce uint) {
-	c.MqttClient.Disconnect(quiesce)
-	c.MqttClient = nil
+	t := c.getTransport()
+	if t == nil {
+		return
+	}
+	t.Disconnect(quiesce)
+
+	c.mu.Lock()
+	c.transport = nil
+	c.topics = make(map[string]bool)
+	c.connected = false
+	c.mu.Unlock()
+	c.connectionEvent(ConnectionDisconnected, nil)
 }
 
 // Helper function to bootstrap a unconfigured device.
 func (c *client) WifiBootstrap(essid string, password string) error {
-	c.opts.Username = "initialconnection" // username is part of the topic: the credentials cant/were-not used for this connection, so we are just overwriting them
-	c.opts.Password = ""
-	// first, subscribe to these special endpoints:
-	c.MqttClient.Subscribe(c.getDeviceTopic("credentials"), 0, nil).Wait()
-	// ..and assemble our commands:
-	c.sendCommand(&commandHeader{Command: MessageJoinNetwork, WifiSsid: essid, WifiPassword: password, RequestId: "0123456789ABCDEF"})
-	c.sendCommand(&commandHeader{Command: MessageAuthoriseUserRequest, RequestId: "01234567890ABCDEF", Id: "00000000-0000-0000-0000-000000000000"})
-	c.sendCommand(&commandHeader{Command: MessageCloseAccessPoint})
-	return nil
+	return c.WifiBootstrapContext(context.Background(), essid, password)
+}
+
+// WifiBootstrapContext is WifiBootstrap with a context.
+// See Provision for a variant returning the credentials of the device.
+func (c *client) WifiBootstrapContext(ctx context.Context, essid string, password string) error {
+	_, err := c.Provision(ctx, &ProvisionOpts{Ssid: essid, Password: password})
+	return err
 }
 
 // SetState sets the fan to given state
 func (c *client) SetState(state *FanState) error {
-	cmd := &commandHeader{Command: "STATE-SET", Data: state}
-	return c.sendCommand(cmd)
+	return c.SetStateContext(context.Background(), state)
+}
+
+// SetStateContext sets the fan to given state, giving up
+// once the context expires.
+func (c *client) SetStateContext(ctx context.Context, state *FanState) error {
+	cmd := &commandHeader{Command: "STATE-SET", Data: adaptState(c.opts.Model, state)}
+	return c.sendCommand(ctx, cmd)
+}
+
+// ApplyState validates the state assembled by given builder
+// against our model and sends it to the device
+func (c *client) ApplyState(ctx context.Context, b *StateBuilder) error {
+	state, err := b.Build(c.opts.Model)
+	if err != nil {
+		return err
+	}
+	return c.SetStateContext(ctx, state)
 }
 
 // RequestCurrentState asks the connected device to return ENVIRONMENTAL-CURRENT-SENSORT-DATA
 // and CURRENT-STATE messages
 func (c *client) RequestCurrentState() error {
-	cmd := &commandHeader{Command: "REQUEST-CURRENT-STATE"}
-	return c.sendCommand(cmd)
+	return c.RequestCurrentStateContext(context.Background())
+}
+
+// RequestCurrentStateContext is RequestCurrentState with a context.
+func (c *client) RequestCurrentStateContext(ctx context.Context) error {
+	cmd := &commandHeader{Command: MessageRequestCurrentState}
+	return c.sendCommand(ctx, cmd)
+}
+
+// RequestFaults asks the connected device to return a CURRENT-FAULTS message
+func (c *client) RequestFaults() error {
+	return c.RequestFaultsContext(context.Background())
+}
+
+// RequestFaultsContext is RequestFaults with a context.
+func (c *client) RequestFaultsContext(ctx context.Context) error {
+	cmd := &commandHeader{Command: MessageRequestFaults}
+	return c.sendCommand(ctx, cmd)
 }
 
 // sendCommand delivers given command to the device
-func (c *client) sendCommand(cmd *commandHeader) error {
+func (c *client) sendCommand(ctx context.Context, cmd *commandHeader) error {
+	return c.publishCommand(ctx, c.getDeviceTopic("command"), cmd)
+}
+
+// publishCommand delivers given command to a specific command topic
+func (c *client) publishCommand(ctx context.Context, topic string, cmd *commandHeader) error {
+	t := c.getTransport()
+	if t == nil {
+		return ErrNotConnected
+	}
 	cmd.TimeString = time.Now().UTC().Format(time.RFC3339Nano)
 
 	raw, err := json.Marshal(cmd)
-	if c.opts.Debug {
-		fmt.Printf("SENDTO: %s\n", raw)
-	}
 	if err == nil {
-		if token := c.MqttClient.Publish(c.getDeviceTopic("command"), 1, false, raw); token.Wait() && token.Error() != nil {
-			err = token.Error()
-		}
+		c.log(LevelDebug, fmt.Sprintf("sending %s", raw), Fields{FieldTopic: topic, FieldDirection: DirectionOut})
+		c.record(DirectionOut, topic, raw)
+		err = t.Publish(ctx, topic, raw)
 	}
 	return err
 }
@@ -151,5 +315,10 @@ func (c *client) sendCommand(cmd *commandHeader) error {
 // getDeviceTopic returns the topic we are supposed to send for
 // this connection
 func (c *client) getDeviceTopic(command string) string {
-	return fmt.Sprintf("%s/%s/%s", c.opts.Model, c.opts.Username, command)
+	return c.getUserTopic(c.opts.Username, command)
+}
+
+// getUserTopic returns the topic of given command for a specific username
+func (c *client) getUserTopic(username string, command string) string {
+	return fmt.Sprintf("%s/%s/%s", c.opts.Model, username, command)
 }
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testSerial      = "NN4-CH-HEA0322B"
+	testStatusTopic = "475/NN4-CH-HEA0322B/status/current"
+	testCmdTopic    = "475/NN4-CH-HEA0322B/command"
+)
+
+// connectMemory returns a client connected via a MemoryTransport.
+// reply is called with the decoded commands published by the client.
+func connectMemory(t *testing.T, reply func(tr *MemoryTransport, cmd map[string]interface{})) (Client, *MemoryTransport) {
+	t.Helper()
+	return connectMemoryModel(t, TypeModelN475, reply)
+}
+
+// connectMemoryModel is connectMemory for a client of given model
+func connectMemoryModel(t *testing.T, model string, reply func(tr *MemoryTransport, cmd map[string]interface{})) (Client, *MemoryTransport) {
+	t.Helper()
+	tr := NewMemoryTransport()
+	if reply != nil {
+		tr.OnPublish = func(topic string, payload []byte) {
+			cmd := make(map[string]interface{})
+			if err := json.Unmarshal(payload, &cmd); err != nil {
+				t.Errorf("client published invalid json %s: %v", payload, err)
+				return
+			}
+			reply(tr, cmd)
+		}
+	}
+	c := NewClient(&ClientOpts{Username: testSerial, Model: model, Transport: tr})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	return c, tr
+}
+
+// publishedCommands returns the msg field of all commands published via tr
+func publishedCommands(tr *MemoryTransport) []string {
+	var cmds []string
+	for _, m := range tr.Published() {
+		cmd := &commandHeader{}
+		if json.Unmarshal(m.Payload, cmd) == nil {
+			cmds = append(cmds, cmd.Command)
+		}
+	}
+	return cmds
+}
+
+func TestClientReconnectResubscribes(t *testing.T) {
+	tr := NewMemoryTransport()
+	c := NewClient(&ClientOpts{Username: testSerial, Model: TypeModelN475, Transport: tr})
+	states := make(chan string, 8)
+	c.OnEvent(EventConnection, func(ev *Event) {
+		if ce, ok := ev.Connection(); ok {
+			states <- ce.State
+		}
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+
+	if !tr.Subscribed(testStatusTopic) {
+		t.Fatalf("not subscribed to %s after Connect()", testStatusTopic)
+	}
+	if cmds := publishedCommands(tr); len(cmds) != 0 {
+		t.Errorf("initial connect published %v, want nothing", cmds)
+	}
+
+	// the event of the initial connect is sent after Connect() returned
+	select {
+	case got := <-states:
+		if got != ConnectionConnected {
+			t.Fatalf("first connection event %v, want %v", got, ConnectionConnected)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("missing connection event %v", ConnectionConnected)
+	}
+
+	tr.Drop(errors.New("broken pipe"))
+	if tr.Subscribed(testStatusTopic) {
+		t.Fatalf("still subscribed after Drop()")
+	}
+	tr.Reconnect()
+	waitFor(t, "the resubscribe", func() bool { return tr.Subscribed(testStatusTopic) })
+	waitFor(t, "the state requests", func() bool { return len(publishedCommands(tr)) == 2 })
+
+	cmds := publishedCommands(tr)
+	if cmds[0] != MessageRequestCurrentState || cmds[1] != MessageRequestFaults {
+		t.Errorf("reconnect published %v, want [%s %s]", cmds, MessageRequestCurrentState, MessageRequestFaults)
+	}
+	want := []string{ConnectionDisconnected, ConnectionReconnecting, ConnectionConnected}
+	for _, w := range want {
+		select {
+		case got := <-states:
+			if got != w {
+				t.Errorf("connection event %v, want %v", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing connection event %v", w)
+		}
+	}
+}
+
+func TestClientConnectedHandlerCanSend(t *testing.T) {
+	tr := NewMemoryTransport()
+	c := NewClient(&ClientOpts{Username: testSerial, Model: TypeModelN475, Transport: tr})
+	sent := make(chan error, 1)
+	c.OnEvent(EventConnection, func(ev *Event) {
+		if ce, ok := ev.Connection(); ok && ce.State == ConnectionConnected {
+			sent <- c.RequestCurrentState()
+		}
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("RequestCurrentState() from the %s handler failed: %v", ConnectionConnected, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("missing connection event %v", ConnectionConnected)
+	}
+	if cmds := publishedCommands(tr); len(cmds) != 1 || cmds[0] != MessageRequestCurrentState {
+		t.Errorf("published %v, want [%s]", cmds, MessageRequestCurrentState)
+	}
+}
+
+func TestClientDisconnectEvent(t *testing.T) {
+	tr := NewMemoryTransport()
+	c := NewClient(&ClientOpts{Username: testSerial, Model: TypeModelN475, Transport: tr})
+	states := make(chan string, 8)
+	c.OnEvent(EventConnection, func(ev *Event) {
+		if ce, ok := ev.Connection(); ok {
+			states <- ce.State
+		}
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	select {
+	case <-states:
+	case <-time.After(time.Second):
+		t.Fatalf("missing connection event %v", ConnectionConnected)
+	}
+
+	c.Disconnect(0)
+	select {
+	case got := <-states:
+		if got != ConnectionDisconnected {
+			t.Errorf("connection event %v, want %v", got, ConnectionDisconnected)
+		}
+	default:
+		t.Errorf("%s was not dispatched by the time Disconnect() returned", ConnectionDisconnected)
+	}
+}
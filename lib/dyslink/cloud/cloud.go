@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+// Package cloud implements the parts of the dyson account api
+// required to fetch the credentials of the devices of an account.
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// DefaultBaseURL is the base url of the dyson account api
+const DefaultBaseURL = "https://appapi.cp.dyson.com"
+
+// Account states returned by UserStatus
+const (
+	AccountActive       = "ACTIVE"
+	AccountUnregistered = "UNREGISTERED"
+)
+
+// ErrNotLoggedIn is returned if a request requires a token but the client has none
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// APIError is returned if the api replied with an unexpected status code
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: http status %d: %s", e.Method, e.Path, e.StatusCode, e.Body)
+}
+
+// Client talks to the dyson account api
+type Client struct {
+	BaseURL    string       // defaults to DefaultBaseURL
+	HTTPClient *http.Client // defaults to http.DefaultClient
+	Country    string       // two letter country code of the account, defaults to US
+	Culture    string       // locale of the account, defaults to en-US
+	Token      string       // bearer token, set by CompleteLogin
+}
+
+// NewClient returns a new client using the default api endpoint
+func NewClient() *Client {
+	return &Client{BaseURL: DefaultBaseURL, Country: "US", Culture: "en-US"}
+}
+
+// UserStatus is the reply of the userstatus endpoint
+type UserStatus struct {
+	AccountStatus        string `json:"accountStatus"`        // One of the Account* constants
+	AuthenticationMethod string `json:"authenticationMethod"` // eg. EMAIL_PWD_2FA
+}
+
+// Challenge is returned by BeginLogin and is passed to CompleteLogin
+// together with the one time password sent via email.
+type Challenge struct {
+	Email       string
+	ChallengeId string `json:"challengeId"`
+}
+
+// loginReply is the reply of the verify endpoint
+type loginReply struct {
+	Account   string `json:"account"`
+	Token     string `json:"token"`
+	TokenType string `json:"tokenType"`
+}
+
+// UserStatus returns the state of the account with given email
+func (c *Client) UserStatus(ctx context.Context, email string) (*UserStatus, error) {
+	st := &UserStatus{}
+	err := c.do(ctx, http.MethodPost, "/v3/userregistration/email/userstatus", c.localeQuery(false),
+		map[string]string{"email": email}, st)
+	return st, err
+}
+
+// BeginLogin starts the login of given account. Dyson sends a one
+// time password to the email address of the account.
+func (c *Client) BeginLogin(ctx context.Context, email string) (*Challenge, error) {
+	st, err := c.UserStatus(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if st.AccountStatus != AccountActive {
+		return nil, fmt.Errorf("account %s is not active: %s", email, st.AccountStatus)
+	}
+
+	ch := &Challenge{Email: email}
+	err = c.do(ctx, http.MethodPost, "/v3/userregistration/email/auth", c.localeQuery(true),
+		map[string]string{"email": email}, ch)
+	if err != nil {
+		return nil, err
+	}
+	if ch.ChallengeId == "" {
+		return nil, fmt.Errorf("no challengeId returned")
+	}
+	return ch, nil
+}
+
+// CompleteLogin finishes the login using the password of the account and
+// the one time password received via email. The token is stored in c.Token.
+func (c *Client) CompleteLogin(ctx context.Context, ch *Challenge, password string, otp string) error {
+	reply := &loginReply{}
+	err := c.do(ctx, http.MethodPost, "/v3/userregistration/email/verify", nil, map[string]string{
+		"email":       ch.Email,
+		"password":    password,
+		"challengeId": ch.ChallengeId,
+		"otpCode":     otp,
+	}, reply)
+	if err != nil {
+		return err
+	}
+	if reply.Token == "" {
+		return fmt.Errorf("no token returned")
+	}
+	c.Token = reply.Token
+	return nil
+}
+
+// Devices returns the devices registered to the account
+func (c *Client) Devices(ctx context.Context) ([]*Device, error) {
+	if c.Token == "" {
+		return nil, ErrNotLoggedIn
+	}
+	devices := []*Device{}
+	err := c.do(ctx, http.MethodGet, "/v2/provisioningservice/manifest", nil, nil, &devices)
+	return devices, err
+}
+
+// IoTCredentials returns the credentials to connect to
+// given device via the cloud mqtt endpoint
+func (c *Client) IoTCredentials(ctx context.Context, serial string) (*IoTCredentials, error) {
+	if c.Token == "" {
+		return nil, ErrNotLoggedIn
+	}
+	creds := &IoTCredentials{}
+	err := c.do(ctx, http.MethodPost, "/v2/authorize/iot-credentials", nil,
+		map[string]string{"Serial": serial}, creds)
+	return creds, err
+}
+
+// localeQuery returns the query parameters required by the login endpoints
+func (c *Client) localeQuery(culture bool) url.Values {
+	q := url.Values{}
+	q.Set("country", c.Country)
+	if culture {
+		q.Set("culture", c.Culture)
+	}
+	return q
+}
+
+// do sends a request with a json encoded body (if not nil) and decodes the reply into out
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	u := base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var rbody *bytes.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rbody = bytes.NewReader(buf)
+	} else {
+		rbody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, rbody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "android client")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: string(buf)}
+	}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return fmt.Errorf("%s %s: parsing reply: %w", method, path, err)
+	}
+	return nil
+}
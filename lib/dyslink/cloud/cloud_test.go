@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+)
+
+const (
+	testEmail    = "user@example.com"
+	testPassword = "account-secret"
+	testOTP      = "123456"
+	testToken    = "bearer-token"
+	testHash     = "aGFzaGVkLXBhc3N3b3Jk"
+)
+
+// encryptLocalCredentials is the inverse of DecryptLocalCredentials
+func encryptLocalCredentials(t *testing.T, plain []byte) string {
+	n := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(n)}, n)...)
+	return encryptRaw(t, plain)
+}
+
+// encryptRaw encrypts plain, which must be a multiple of the block size, without padding it
+func encryptRaw(t *testing.T, plain []byte) string {
+	block, err := aes.NewCipher(localCredentialsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(enc, plain)
+	return base64.StdEncoding.EncodeToString(enc)
+}
+
+// fakeAPI implements the endpoints used by Client
+func fakeAPI(t *testing.T) *httptest.Server {
+	creds := encryptLocalCredentials(t, []byte(`{"serial":"NN4-CH-HEA0322B","apPasswordHash":"`+testHash+`"}`))
+
+	mux := http.NewServeMux()
+	reply := func(w http.ResponseWriter, v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+	body := func(r *http.Request) map[string]string {
+		m := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&m)
+		return m
+	}
+	mux.HandleFunc("/v3/userregistration/email/userstatus", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Query().Get("country") != "US" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		status := AccountUnregistered
+		if body(r)["email"] == testEmail {
+			status = AccountActive
+		}
+		reply(w, &UserStatus{AccountStatus: status, AuthenticationMethod: "EMAIL_PWD_2FA"})
+	})
+	mux.HandleFunc("/v3/userregistration/email/auth", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("culture") != "en-US" || body(r)["email"] != testEmail {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		reply(w, map[string]string{"challengeId": "challenge-1"})
+	})
+	mux.HandleFunc("/v3/userregistration/email/verify", func(w http.ResponseWriter, r *http.Request) {
+		b := body(r)
+		switch {
+		case b["challengeId"] != "challenge-1" || b["otpCode"] != testOTP:
+			http.Error(w, `{"message":"invalid otp"}`, http.StatusBadRequest)
+		case b["password"] != testPassword:
+			http.Error(w, `{"message":"invalid password"}`, http.StatusUnauthorized)
+		default:
+			reply(w, &loginReply{Account: "account-id", Token: testToken, TokenType: "Bearer"})
+		}
+	})
+	mux.HandleFunc("/v2/provisioningservice/manifest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		reply(w, []*Device{{
+			Serial:           "NN4-CH-HEA0322B",
+			Name:             "Living room",
+			ProductType:      dyslink.TypeModelN475,
+			LocalCredentials: creds,
+		}})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestLogin(t *testing.T) {
+	srv := fakeAPI(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := NewClient()
+	c.BaseURL = srv.URL
+	ch, err := c.BeginLogin(ctx, testEmail)
+	if err != nil {
+		t.Fatalf("BeginLogin() failed: %v", err)
+	}
+	if err := c.CompleteLogin(ctx, ch, testPassword, testOTP); err != nil {
+		t.Fatalf("CompleteLogin() failed: %v", err)
+	}
+	if c.Token != testToken {
+		t.Errorf("Token = %q, want %q", c.Token, testToken)
+	}
+
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		t.Fatalf("Devices() failed: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Devices() = %v, want a single device", devices)
+	}
+	creds, err := devices[0].Credentials()
+	if err != nil {
+		t.Fatalf("Credentials() failed: %v", err)
+	}
+	want := dyslink.Credentials{Serial: "NN4-CH-HEA0322B", PasswordHash: testHash, Model: dyslink.TypeModelN475}
+	if *creds != want {
+		t.Errorf("Credentials() = %+v, want %+v", creds, want)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	srv := fakeAPI(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		otp        string
+		wantStatus int // expected APIError status, 0 for other errors
+	}{
+		{"inactive account", "unknown@example.com", testPassword, testOTP, 0},
+		{"wrong one time password", testEmail, testPassword, "000000", http.StatusBadRequest},
+		{"wrong password", testEmail, "wrong", testOTP, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient()
+			c.BaseURL = srv.URL
+			ch, err := c.BeginLogin(ctx, tt.email)
+			if err == nil {
+				err = c.CompleteLogin(ctx, ch, tt.password, tt.otp)
+			}
+			if err == nil {
+				t.Fatalf("login succeeded, want an error")
+			}
+			var aerr *APIError
+			if tt.wantStatus == 0 {
+				if errors.As(err, &aerr) {
+					t.Errorf("got %v, want a non-api error", err)
+				}
+			} else if !errors.As(err, &aerr) || aerr.StatusCode != tt.wantStatus {
+				t.Errorf("got %v, want an api error with status %d", err, tt.wantStatus)
+			}
+			if c.Token != "" {
+				t.Errorf("Token = %q after a failed login", c.Token)
+			}
+			if _, err := c.Devices(ctx); !errors.Is(err, ErrNotLoggedIn) {
+				t.Errorf("Devices() = %v, want ErrNotLoggedIn", err)
+			}
+		})
+	}
+}
+
+func TestDecryptLocalCredentials(t *testing.T) {
+	block := bytes.Repeat([]byte("x"), aes.BlockSize)
+	tests := []struct {
+		name string
+		blob string
+		want string // empty if an error is expected
+	}{
+		{"round trip", encryptLocalCredentials(t, []byte(`{"serial":"NN4-CH-HEA0322B","apPasswordHash":"`+testHash+`"}`)), testHash},
+		{"full padding block", encryptLocalCredentials(t, []byte(`{"apPasswordHash":"abcdefghijk"}`)), "abcdefghijk"},
+		{"invalid base64", "not base64!", ""},
+		{"invalid length", base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"zero padding", encryptRaw(t, append(append([]byte(nil), block[:15]...), 0)), ""},
+		{"padding too long", encryptRaw(t, append(append([]byte(nil), block[:15]...), aes.BlockSize+1)), ""},
+		{"inconsistent padding", encryptRaw(t, append(append([]byte(nil), block[:13]...), 2, 3, 3)), ""},
+		{"no password hash", encryptLocalCredentials(t, []byte(`{"serial":"NN4-CH-HEA0322B"}`)), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptLocalCredentials(tt.blob)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("DecryptLocalCredentials() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecryptLocalCredentials() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DecryptLocalCredentials() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
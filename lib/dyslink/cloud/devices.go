@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package cloud
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+)
+
+// Device is a device listed in the manifest of an account
+type Device struct {
+	Serial              string `json:"Serial"`
+	Name                string `json:"Name"`
+	Version             string `json:"Version"`
+	ProductType         string `json:"ProductType"`      // One of the dyslink.TypeModel* constants
+	ConnectionType      string `json:"ConnectionType"`   // eg. wssWithToken
+	LocalCredentials    string `json:"LocalCredentials"` // encrypted, see Credentials
+	AutoUpdate          bool   `json:"AutoUpdate"`
+	NewVersionAvailable bool   `json:"NewVersionAvailable"`
+}
+
+// localCredentials is the decrypted LocalCredentials blob
+type localCredentials struct {
+	Serial       string `json:"serial"`
+	PasswordHash string `json:"apPasswordHash"`
+}
+
+// localCredentialsKey is the (static) key used to encrypt LocalCredentials
+var localCredentialsKey = func() []byte {
+	k := make([]byte, 32)
+	for i := range k {
+		k[i] = byte(i + 1)
+	}
+	return k
+}()
+
+// DecryptLocalCredentials decrypts the LocalCredentials blob of a device
+// and returns the password hash of the local mqtt broker.
+func DecryptLocalCredentials(blob string) (string, error) {
+	enc, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return "", fmt.Errorf("decoding local credentials: %w", err)
+	}
+	if len(enc) == 0 || len(enc)%aes.BlockSize != 0 {
+		return "", fmt.Errorf("local credentials have an invalid length of %d bytes", len(enc))
+	}
+
+	block, err := aes.NewCipher(localCredentialsKey)
+	if err != nil {
+		return "", err
+	}
+	dec := make([]byte, len(enc))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(dec, enc)
+	dec, err = unpadPKCS7(dec)
+	if err != nil {
+		return "", fmt.Errorf("decrypting local credentials: %w", err)
+	}
+
+	lc := &localCredentials{}
+	if err := json.Unmarshal(dec, lc); err != nil {
+		return "", fmt.Errorf("parsing local credentials: %w", err)
+	}
+	if lc.PasswordHash == "" {
+		return "", fmt.Errorf("local credentials contain no apPasswordHash")
+	}
+	return lc.PasswordHash, nil
+}
+
+// unpadPKCS7 strips the PKCS#7 padding of a decrypted message
+func unpadPKCS7(buf []byte) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("empty message")
+	}
+	n := int(buf[len(buf)-1])
+	if n < 1 || n > aes.BlockSize || n > len(buf) {
+		return nil, fmt.Errorf("invalid padding length %d", n)
+	}
+	for _, b := range buf[len(buf)-n:] {
+		if int(b) != n {
+			return nil, fmt.Errorf("invalid padding")
+		}
+	}
+	return buf[:len(buf)-n], nil
+}
+
+// Credentials returns the local mqtt credentials of the device
+func (d *Device) Credentials() (*dyslink.Credentials, error) {
+	hash, err := DecryptLocalCredentials(d.LocalCredentials)
+	if err != nil {
+		return nil, fmt.Errorf("device %s: %w", d.Serial, err)
+	}
+	return &dyslink.Credentials{Serial: d.Serial, PasswordHash: hash, Model: d.ProductType}, nil
+}
+
+// ClientOpts returns the options to connect to the local broker
+// of the device at given address (in the tcp://IP:PORT format).
+func (d *Device) ClientOpts(address string) (*dyslink.ClientOpts, error) {
+	creds, err := d.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	opts := &dyslink.ClientOpts{DeviceAddress: address}
+	creds.Apply(opts)
+	return opts, nil
+}
+
+// IoTCredentials are the credentials of the cloud mqtt endpoint of a device
+type IoTCredentials struct {
+	Endpoint       string `json:"Endpoint"` // hostname of the aws iot endpoint
+	IoTCredentials struct {
+		ClientId             string `json:"ClientId"`
+		CustomAuthorizerName string `json:"CustomAuthorizerName"`
+		TokenKey             string `json:"TokenKey"`
+		TokenSignature       string `json:"TokenSignature"`
+		TokenValue           string `json:"TokenValue"`
+	} `json:"IoTCredentials"`
+}
+
+// CloudOpts returns the options to connect via the cloud mqtt endpoint
+func (ic *IoTCredentials) CloudOpts() *dyslink.CloudOpts {
+	return &dyslink.CloudOpts{
+		Endpoint:       ic.Endpoint,
+		ClientId:       ic.IoTCredentials.ClientId,
+		AuthorizerName: ic.IoTCredentials.CustomAuthorizerName,
+		TokenKey:       ic.IoTCredentials.TokenKey,
+		TokenValue:     ic.IoTCredentials.TokenValue,
+		TokenSignature: ic.IoTCredentials.TokenSignature,
+	}
+}
+
+// CloudClientOpts fetches the iot credentials of given device and returns
+// the options to connect to it via the cloud mqtt endpoint.
+// The credentials are fetched again before each reconnect.
+func (c *Client) CloudClientOpts(ctx context.Context, d *Device) (*dyslink.ClientOpts, error) {
+	ic, err := c.IoTCredentials(ctx, d.Serial)
+	if err != nil {
+		return nil, err
+	}
+	cloud := ic.CloudOpts()
+	cloud.Refresh = func(ctx context.Context) (*dyslink.CloudOpts, error) {
+		ic, err := c.IoTCredentials(ctx, d.Serial)
+		if err != nil {
+			return nil, err
+		}
+		return ic.CloudOpts(), nil
+	}
+	return &dyslink.ClientOpts{
+		Username: d.Serial,
+		Model:    d.ProductType,
+		Cloud:    cloud,
+	}, nil
+}
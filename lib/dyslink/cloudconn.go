@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"net"
+	"net/http"
+)
+
+// CloudOpts configures a connection via the cloud (aws iot) mqtt endpoint
+// instead of the local broker of the device. The values are issued by the
+// dyson account api, see cloud.IoTCredentials.
+type CloudOpts struct {
+	Endpoint       string // hostname of the aws iot endpoint, port 443 is used unless given as host:port
+	ClientId       string // mqtt client id, must match the issued token
+	AuthorizerName string // name of the aws iot custom authorizer
+	TokenKey       string // name of the header carrying the token
+	TokenValue     string
+	TokenSignature string
+	TLSConfig      *tls.Config // nil uses the system defaults
+
+	// Refresh returns fresh credentials, as the issued tokens expire. It is
+	// called before each reconnect, the old credentials are reused if nil.
+	Refresh func(context.Context) (*CloudOpts, error)
+}
+
+// brokerAddress returns the websocket url of the cloud endpoint
+func (co *CloudOpts) brokerAddress() string {
+	if _, _, err := net.SplitHostPort(co.Endpoint); err == nil {
+		return fmt.Sprintf("wss://%s/mqtt", co.Endpoint)
+	}
+	return fmt.Sprintf("wss://%s:443/mqtt", co.Endpoint)
+}
+
+// apply configures the paho options to connect to the cloud endpoint
+func (co *CloudOpts) apply(mqttOpts *mqtt.ClientOptions) {
+	mqttOpts.AddBroker(co.brokerAddress())
+	mqttOpts.SetClientID(co.ClientId)
+	mqttOpts.SetCleanSession(true)
+	if co.TLSConfig != nil {
+		mqttOpts.SetTLSConfig(co.TLSConfig)
+	}
+
+	h := http.Header{}
+	h.Set("X-Amz-CustomAuthorizer-Name", co.AuthorizerName)
+	h.Set("X-Amz-CustomAuthorizer-Signature", co.TokenSignature)
+	h.Set(co.TokenKey, co.TokenValue)
+	mqttOpts.SetHTTPHeaders(h)
+}
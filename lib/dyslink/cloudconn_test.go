@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+	"github.com/adrian-bl/dyslink/lib/dyslink/sim"
+	"golang.org/x/net/websocket"
+)
+
+// cloudStub is a stub of the aws iot endpoint: it checks the custom
+// authorizer headers and passes the websocket to a simulated fan
+type cloudStub struct {
+	mu     sync.Mutex
+	valid  string   // the currently valid token
+	tokens []string // the tokens of all connection attempts
+	conns  []*websocket.Conn
+}
+
+func (s *cloudStub) ServeHTTP(w http.ResponseWriter, r *http.Request, fan *sim.Fan) {
+	token := r.Header.Get("X-Token")
+	s.mu.Lock()
+	s.tokens = append(s.tokens, token)
+	ok := token == s.valid && r.Header.Get("X-Amz-CustomAuthorizer-Name") == "authorizer"
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "token expired", http.StatusUnauthorized)
+		return
+	}
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		s.mu.Lock()
+		s.conns = append(s.conns, ws)
+		s.mu.Unlock()
+		fan.ServeConn(ws)
+	}}.ServeHTTP(w, r)
+}
+
+// expire rotates the valid token and drops all connections
+func (s *cloudStub) expire(valid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.valid = valid
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *cloudStub) seen() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+func TestCloudReconnectRefreshesToken(t *testing.T) {
+	fan := sim.NewFan(&sim.Opts{EnvInterval: -1})
+	stub := &cloudStub{valid: "token-1"}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.ServeHTTP(w, r, fan)
+	}))
+	defer srv.Close()
+
+	cloudOpts := func(token string) *dyslink.CloudOpts {
+		return &dyslink.CloudOpts{
+			Endpoint:       strings.TrimPrefix(srv.URL, "https://"),
+			ClientId:       "client-id",
+			AuthorizerName: "authorizer",
+			TokenKey:       "X-Token",
+			TokenValue:     token,
+			TokenSignature: "signature",
+			TLSConfig:      srv.Client().Transport.(*http.Transport).TLSClientConfig,
+		}
+	}
+	co := cloudOpts("token-1")
+	co.Refresh = func(ctx context.Context) (*dyslink.CloudOpts, error) {
+		return cloudOpts("token-2"), nil
+	}
+
+	c := dyslink.NewClient(&dyslink.ClientOpts{Username: fan.Serial(), Model: fan.Model(), Cloud: co})
+	connected := make(chan struct{}, 4)
+	c.OnEvent(dyslink.EventConnection, func(ev *dyslink.Event) {
+		if ce, ok := ev.Connection(); ok && ce.State == dyslink.ConnectionConnected {
+			connected <- struct{}{}
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.ConnectContext(ctx); err != nil {
+		t.Fatalf("ConnectContext() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+	<-connected
+
+	stub.expire("token-2")
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reconnect, tokens sent: %v", stub.seen())
+	}
+	if got := stub.seen(); len(got) != 2 || got[0] != "token-1" || got[1] != "token-2" {
+		t.Errorf("tokens sent = %v, want [token-1 token-2]", got)
+	}
+
+	// the new connection is usable
+	if _, err := c.GetState(ctx); err != nil {
+		t.Errorf("GetState() after the reconnect failed: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// StateMismatchError is returned by SetStateConfirmed if the device
+// reported a different value than requested.
+type StateMismatchError struct {
+	Field string // The field in question, eg. `fnsp`
+	Want  string // The value we requested
+	Got   string // The value reported by the device
+}
+
+func (e *StateMismatchError) Error() string {
+	return fmt.Sprintf("device reported %s=%s, wanted %s", e.Field, e.Got, e.Want)
+}
+
+// SetStateConfirmed sets the fan to given state and waits until the device
+// confirms the change via a STATE-CHANGE message.
+// A *StateMismatchError is returned as soon as the device reports a state which
+// does not match all requested fields, a *TimeoutError if no STATE-CHANGE was
+// received in time.
+func (c *client) SetStateConfirmed(ctx context.Context, state *FanState) (*ProductState, error) {
+	ctx, cancel := replyContext(ctx)
+	defer cancel()
+
+	w := c.addWaiter(MessageStateChange)
+	defer c.removeWaiter(w)
+
+	if err := c.SetStateContext(ctx, state); err != nil {
+		return nil, err
+	}
+
+	// compare against what we actually sent to this model
+	want := adaptState(c.opts.Model, state)
+	select {
+	case msg := <-w.ch:
+		sc, ok := msg.(*StateChange)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s payload %T", MessageStateChange, msg)
+		}
+		if mismatch := compareState(want, sc.Current); mismatch != nil {
+			return nil, mismatch
+		}
+		return sc.Current, nil
+	case <-ctx.Done():
+		return nil, &TimeoutError{Command: MessageStateChange, Err: ctx.Err()}
+	}
+}
+
+// compareState returns an error for the first field in want which was
+// not applied to got. Empty fields and one-shot fields such as the
+// sleep timer or filter reset are ignored.
+// got is expected to be normalized, so want may use either spelling of
+// the oscillation values.
+func compareState(want *FanState, got *ProductState) *StateMismatchError {
+	fields := []struct {
+		name string
+		want string
+		got  string
+	}{
+		{"fmod", want.FanMode, got.FanMode},
+		{"fnsp", want.FanSpeed, got.FanSpeed},
+		{"oson", normalizeOscillation(want.Oscillate), got.Oscillate},
+		{"rhtm", want.StandbyMonitoring, got.StandbyMonitoring},
+		{"qtar", want.QualityTarget, got.QualityTarget},
+		{"nmod", want.NightMode, got.NightMode},
+		{"hmod", want.HeatMode, got.HeatMode},
+		{"hmax", want.HeatTarget, got.HeatTarget},
+		{"ffoc", want.FocusedMode, got.FocusedMode},
+		{"fpwr", want.FanPower, got.FanPower},
+		{"auto", want.AutoMode, got.AutoMode},
+		{"osal", want.OscillationLow, got.OscillationLow},
+		{"osau", want.OscillationHigh, got.OscillationHigh},
+		{"ancp", want.AngleCombination, got.AngleCombination},
+		{"fdir", want.FrontAirflow, got.FrontAirflow},
+	}
+	for _, f := range fields {
+		if f.want == "" || stateValueEqual(f.want, f.got) {
+			continue
+		}
+		return &StateMismatchError{Field: f.name, Want: f.want, Got: f.got}
+	}
+	return nil
+}
+
+// stateValueEqual compares two state values, taking care of
+// zero-padded numbers such as "0007".
+func stateValueEqual(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ia, erra := strconv.Atoi(a)
+	ib, errb := strconv.Atoi(b)
+	return erra == nil && errb == nil && ia == ib
+}
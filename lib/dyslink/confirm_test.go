@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClientSetStateConfirmed(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   string
+		state   FanState
+		change  string // the STATE-CHANGE sent in reply to STATE-SET, none if empty
+		timeout time.Duration
+		want    ProductState // the fields to check on success
+		err     interface{}
+	}{
+		{
+			name:   "confirmed",
+			model:  TypeModelN475,
+			state:  FanState{FanMode: FanModeOn, FanSpeed: "0007"},
+			change: `{"msg":"STATE-CHANGE","product-state":{"fmod":["OFF","FAN"],"fnsp":["0001","0007"]}}`,
+			want:   ProductState{FanMode: FanModeOn, FanSpeed: "0007", FanPower: FanPowerOn, AutoMode: AutoModeOff},
+		},
+		{
+			name:   "confirmed v2",
+			model:  TypeModelN438,
+			state:  FanState{FanMode: FanModeAuto, Oscillate: OscillateOn},
+			change: `{"msg":"STATE-CHANGE","product-state":{"fpwr":["OFF","ON"],"auto":["OFF","ON"],"oson":["OFF","ON"]}}`,
+			want:   ProductState{FanMode: FanModeAuto, FanPower: FanPowerOn, AutoMode: AutoModeOn, Oscillate: OscillateOn},
+		},
+		{
+			name:   "confirmed v2 spelling",
+			model:  TypeModelN438,
+			state:  FanState{FanPower: FanPowerOn, Oscillate: OscillateOnV2},
+			change: `{"msg":"STATE-CHANGE","product-state":{"fpwr":["OFF","ON"],"auto":["OFF","OFF"],"oson":["OIOF","OION"]}}`,
+			want:   ProductState{FanMode: FanModeOn, FanPower: FanPowerOn, AutoMode: AutoModeOff, Oscillate: OscillateOn},
+		},
+		{
+			name:   "mismatch",
+			model:  TypeModelN475,
+			state:  FanState{FanMode: FanModeOn, FanSpeed: "0007"},
+			change: `{"msg":"STATE-CHANGE","product-state":{"fmod":["OFF","FAN"],"fnsp":["0001","0004"]}}`,
+			err:    &StateMismatchError{Field: "fnsp", Want: "0007", Got: "0004"},
+		},
+		{
+			name:   "mismatch v2",
+			model:  TypeModelN438,
+			state:  FanState{FanMode: FanModeAuto},
+			change: `{"msg":"STATE-CHANGE","product-state":{"fpwr":["OFF","ON"],"auto":["OFF","OFF"]}}`,
+			err:    &StateMismatchError{Field: "auto", Want: AutoModeOn, Got: AutoModeOff},
+		},
+		{
+			name:    "timeout",
+			model:   TypeModelN475,
+			state:   FanState{FanMode: FanModeOn, FanSpeed: "0007"},
+			timeout: 100 * time.Millisecond,
+			err:     &TimeoutError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := connectMemoryModel(t, tt.model, func(tr *MemoryTransport, cmd map[string]interface{}) {
+				if cmd["msg"] == "STATE-SET" && tt.change != "" {
+					tr.Deliver(tt.model+"/"+testSerial+"/status/current", []byte(tt.change))
+				}
+			})
+			defer c.Disconnect(0)
+
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			ps, err := c.SetStateConfirmed(ctx, &tt.state)
+			switch want := tt.err.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("SetStateConfirmed() failed: %v", err)
+				}
+				if ps.FanMode != tt.want.FanMode || ps.FanPower != tt.want.FanPower || ps.AutoMode != tt.want.AutoMode ||
+					ps.Oscillate != tt.want.Oscillate || ps.FanSpeed != tt.want.FanSpeed {
+					t.Errorf("SetStateConfirmed() = %+v, want %+v", ps, tt.want)
+				}
+			case *StateMismatchError:
+				var got *StateMismatchError
+				if !errors.As(err, &got) {
+					t.Fatalf("SetStateConfirmed() error = %v, want a StateMismatchError", err)
+				}
+				if *got != *want {
+					t.Errorf("SetStateConfirmed() error = %+v, want %+v", got, want)
+				}
+				if ctx.Err() != nil {
+					t.Errorf("SetStateConfirmed() waited for the timeout instead of reporting the mismatch")
+				}
+			case *TimeoutError:
+				if !errors.As(err, &want) {
+					t.Fatalf("SetStateConfirmed() error = %v, want a TimeoutError", err)
+				}
+				if want.Command != MessageStateChange || !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("SetStateConfirmed() error = %+v", want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Environment variables read by CredentialsFromEnv
+const (
+	EnvCredentialsFile = "DYSLINK_CREDENTIALS"   // path to a credentials file
+	EnvSerial          = "DYSLINK_SERIAL"        // serial (username) of the device
+	EnvPassword        = "DYSLINK_PASSWORD"      // plain text password, as printed on the sticker
+	EnvPasswordHash    = "DYSLINK_PASSWORD_HASH" // hashed password, as returned by the cloud or DEVICE-CREDENTIALS
+)
+
+// Credentials are the local mqtt credentials of a device.
+// The json encoding is compatible to a DEVICE-CREDENTIALS reply.
+type Credentials struct {
+	Serial       string `json:"serialNumber"`
+	PasswordHash string `json:"apPasswordHash,omitempty"`
+	Password     string `json:"password,omitempty"`    // plain text password, only used if PasswordHash is empty
+	Model        string `json:"productType,omitempty"` // One of the TypeModel* constants
+}
+
+// Apply copies the credentials into given options.
+// The model is only set if opts has none.
+func (c *Credentials) Apply(opts *ClientOpts) {
+	opts.Username = c.Serial
+	opts.Password = c.Password
+	opts.PasswordHash = c.PasswordHash
+	if opts.Model == "" {
+		opts.Model = c.Model
+	}
+}
+
+// LoadCredentials reads a credentials file
+func LoadCredentials(path string) (*Credentials, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Credentials{}
+	if err := json.Unmarshal(buf, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if c.Serial == "" || (c.PasswordHash == "" && c.Password == "") {
+		return nil, fmt.Errorf("%s: serialNumber and apPasswordHash (or password) are required", path)
+	}
+	return c, nil
+}
+
+// SaveCredentials writes a credentials file which is only readable by the current user
+func SaveCredentials(path string, c *Credentials) error {
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0600)
+}
+
+// Save writes the credentials received during bootstrap into a credentials file
+func (dc *DeviceCredentials) Save(path string, model string) error {
+	return SaveCredentials(path, &Credentials{Serial: dc.SerialNumber, PasswordHash: dc.Password, Model: model})
+}
+
+// CredentialsFromEnv returns the credentials configured via the Env* variables.
+// A credentials file takes precedence over the other variables.
+// nil is returned if none are set.
+func CredentialsFromEnv() (*Credentials, error) {
+	if path := os.Getenv(EnvCredentialsFile); path != "" {
+		return LoadCredentials(path)
+	}
+	c := &Credentials{
+		Serial:       os.Getenv(EnvSerial),
+		Password:     os.Getenv(EnvPassword),
+		PasswordHash: os.Getenv(EnvPasswordHash),
+	}
+	if c.Serial == "" && c.Password == "" && c.PasswordHash == "" {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// ResolveCredentials loads the credentials file at path or, if path
+// is empty, falls back to CredentialsFromEnv.
+func ResolveCredentials(path string) (*Credentials, error) {
+	if path != "" {
+		return LoadCredentials(path)
+	}
+	return CredentialsFromEnv()
+}
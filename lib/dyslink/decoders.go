@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"sync"
+	"time"
+)
+
+// A Decoder turns the raw json of a message into its decoded form
+type Decoder func(payload []byte) (interface{}, error)
+
+// RawMessage is delivered for messages without a registered Decoder
+type RawMessage struct {
+	Command string          // The `msg` field of the message
+	Payload json.RawMessage // The unparsed message
+}
+
+func (m *RawMessage) String() string {
+	return fmt.Sprintf("%s: %s", m.Command, m.Payload)
+}
+
+// ErrBuiltinDecoder is returned by RegisterDecoder for commands decoded by this package
+var ErrBuiltinDecoder = errors.New("command has a built-in decoder")
+
+// decoders holds the registered decoders, keyed by command
+var decoders = struct {
+	sync.RWMutex
+	m       map[string]Decoder
+	builtin map[string]bool
+}{m: make(map[string]Decoder), builtin: make(map[string]bool)}
+
+func init() {
+	registerBuiltinDecoder(MessageEnvSensorData, decodeEnvSensorData)
+	registerBuiltinDecoder(MessageCurrentState, decodeCurrentState)
+	registerBuiltinDecoder(MessageStateChange, decodeStateChange)
+	registerBuiltinDecoder(MessageDeviceCredentials, decodeDeviceCredentials)
+	registerBuiltinDecoder(MessageCurrentFaults, func(payload []byte) (interface{}, error) {
+		return parseFaultsPayload(payload)
+	})
+}
+
+// RegisterDecoder registers a decoder for messages with given command
+// (the `msg` field), replacing any existing decoder.
+// Passing a nil decoder removes the registration, causing such
+// messages to be delivered as *RawMessage.
+// The decoders of this package can not be replaced or removed: the
+// client relies on their payload types, ErrBuiltinDecoder is returned.
+func RegisterDecoder(command string, d Decoder) error {
+	decoders.Lock()
+	defer decoders.Unlock()
+	if decoders.builtin[command] {
+		return fmt.Errorf("%s: %w", command, ErrBuiltinDecoder)
+	}
+	if d == nil {
+		delete(decoders.m, command)
+	} else {
+		decoders.m[command] = d
+	}
+	return nil
+}
+
+// registerBuiltinDecoder registers a decoder which can not be replaced
+func registerBuiltinDecoder(command string, d Decoder) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	decoders.m[command] = d
+	decoders.builtin[command] = true
+}
+
+// lookupDecoder returns the decoder of given command or nil
+func lookupDecoder(command string) Decoder {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	return decoders.m[command]
+}
+
+// decodeMessage parses a raw message received from the device
+// and returns it as Event
+func decodeMessage(payload []byte) *Event {
+	hdr := &commandHeader{}
+	err := json.Unmarshal(payload, &hdr)
+	if err != nil {
+		return &Event{Kind: EventRaw, Payload: rawMessage("", payload), Error: err}
+	}
+
+	ev := &Event{Kind: EventKind(hdr.Command), Command: hdr.Command}
+	if t, err := time.Parse(time.RFC3339Nano, hdr.TimeString); err == nil {
+		ev.DeviceTime = t
+	}
+	d := lookupDecoder(hdr.Command)
+	if d == nil {
+		ev.Kind = EventRaw
+		ev.Payload = rawMessage(hdr.Command, payload)
+		return ev
+	}
+	ev.Payload, ev.Error = d(payload)
+	return ev
+}
+
+// rawMessage returns a RawMessage holding a copy of payload
+func rawMessage(command string, payload []byte) *RawMessage {
+	raw := make(json.RawMessage, len(payload))
+	copy(raw, payload)
+	return &RawMessage{Command: command, Payload: raw}
+}
+
+func decodeEnvSensorData(payload []byte) (interface{}, error) {
+	hdr := &commandHeader{}
+	if err := json.Unmarshal(payload, &hdr); err != nil {
+		return nil, err
+	}
+	envstate := &EnvironmentState{}
+	err := mapstructure.Decode(hdr.Data, &envstate)
+	return envstate, err
+}
+
+func decodeCurrentState(payload []byte) (interface{}, error) {
+	hdr := &commandHeader{}
+	if err := json.Unmarshal(payload, &hdr); err != nil {
+		return nil, err
+	}
+	prodstate := &ProductState{}
+	err := mapstructure.Decode(hdr.ProductState, &prodstate)
+	prodstate.normalize()
+	return prodstate, err
+}
+
+func decodeStateChange(payload []byte) (interface{}, error) {
+	hdr := &commandHeader{}
+	if err := json.Unmarshal(payload, &hdr); err != nil {
+		return nil, err
+	}
+	return parseStateChangePayload(hdr.ProductState, hdr.ModeReason)
+}
+
+func decodeDeviceCredentials(payload []byte) (interface{}, error) {
+	devcred := &DeviceCredentials{}
+	err := json.Unmarshal(payload, &devcred)
+	return devcred, err
+}
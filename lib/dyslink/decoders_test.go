@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterDecoder(t *testing.T) {
+	custom := func(payload []byte) (interface{}, error) {
+		return string(payload), nil
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		decoder Decoder
+		wantErr error
+	}{
+		{"replace current state", MessageCurrentState, custom, ErrBuiltinDecoder},
+		{"remove state change", MessageStateChange, nil, ErrBuiltinDecoder},
+		{"replace environment", MessageEnvSensorData, custom, ErrBuiltinDecoder},
+		{"replace credentials", MessageDeviceCredentials, custom, ErrBuiltinDecoder},
+		{"replace faults", MessageCurrentFaults, custom, ErrBuiltinDecoder},
+		{"replace provisioning failure", MessageProvisioningFailed, custom, ErrBuiltinDecoder},
+		{"new command", "TEST-CUSTOM", custom, nil},
+		{"remove new command", "TEST-CUSTOM", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterDecoder(tt.command, tt.decoder); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RegisterDecoder(%s) = %v, want %v", tt.command, err, tt.wantErr)
+			}
+		})
+	}
+
+	// the built-in decoders are still in place
+	ev := decodeMessage([]byte(`{"msg":"CURRENT-STATE","product-state":{"fmod":"FAN"}}`))
+	if ps, ok := ev.ProductState(); !ok || ps.FanMode != FanModeOn {
+		t.Errorf("decodeMessage() = %+v, want a *ProductState", ev)
+	}
+}
+
+func TestRegisterDecoderCustom(t *testing.T) {
+	const command = "TEST-DECODER"
+	if err := RegisterDecoder(command, func(payload []byte) (interface{}, error) {
+		return "decoded", nil
+	}); err != nil {
+		t.Fatalf("RegisterDecoder() failed: %v", err)
+	}
+	defer RegisterDecoder(command, nil)
+
+	ev := decodeMessage([]byte(`{"msg":"TEST-DECODER"}`))
+	if ev.Kind != EventKind(command) || ev.Payload != "decoded" {
+		t.Errorf("decodeMessage() = %+v, want the custom payload", ev)
+	}
+
+	RegisterDecoder(command, nil)
+	ev = decodeMessage([]byte(`{"msg":"TEST-DECODER"}`))
+	if raw, ok := ev.Raw(); !ok || raw.Command != command {
+		t.Errorf("decodeMessage() after removing the decoder = %+v, want a *RawMessage", ev)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiscoveryService is the mDNS service advertised by the devices
+const DiscoveryService = "_dyson_mqtt._tcp.local."
+
+// Defaults used by Discover
+const (
+	DefaultDiscoveryTimeout  = 3 * time.Second // used if the context has no deadline
+	DefaultDiscoveryInterval = time.Second     // interval between queries
+)
+
+// mdnsGroup is the mDNS multicast address
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// DiscoveredDevice is a device found via mDNS
+type DiscoveredDevice struct {
+	Name    string // The mDNS instance name, eg. 475_NN4-CH-HEA0322B
+	Serial  string // The serial (username) of the device
+	Model   string // One of the TypeModel* constants
+	Address string // ip:port of the mqtt broker of the device
+}
+
+// DeviceAddress returns the address in the format expected by ClientOpts
+func (d *DiscoveredDevice) DeviceAddress() string {
+	return "tcp://" + d.Address
+}
+
+// DiscoverOpts configures DiscoverWithOpts
+type DiscoverOpts struct {
+	Interface *net.Interface // The interface to use, nil picks the system default
+	GroupAddr *net.UDPAddr   // The multicast group to query, defaults to 224.0.0.251:5353
+	Interval  time.Duration  // Interval between queries, defaults to DefaultDiscoveryInterval
+	Serial    string         // Stop as soon as the device with this serial was found
+}
+
+// Discover browses the local network for devices until the context
+// expires (or DefaultDiscoveryTimeout passed) and returns all devices found.
+func Discover(ctx context.Context) ([]*DiscoveredDevice, error) {
+	return DiscoverWithOpts(ctx, &DiscoverOpts{})
+}
+
+// FindDevice browses the local network for the device with given serial.
+// Note that the address is only resolved once: the client keeps
+// reconnecting to it, even if the device got a new address.
+func FindDevice(ctx context.Context, serial string) (*DiscoveredDevice, error) {
+	devices, err := DiscoverWithOpts(ctx, &DiscoverOpts{Serial: serial})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if strings.EqualFold(d.Serial, serial) {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("device %s not found", serial)
+}
+
+// DiscoverWithOpts is Discover with options
+func DiscoverWithOpts(ctx context.Context, dopts *DiscoverOpts) ([]*DiscoveredDevice, error) {
+	group := dopts.GroupAddr
+	if group == nil {
+		group = mdnsGroup
+	}
+	interval := dopts.Interval
+	if interval == 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultDiscoveryTimeout)
+		defer cancel()
+	}
+	// stops the query and reader goroutines once we return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Queries are sent from an ephemeral port: responders reply to such
+	// (legacy) queries directly, which also works for responders running
+	// on this host, as multicast loopback is disabled on conns returned
+	// by ListenMulticastUDP. Multicast replies are picked up by mconn.
+	qconn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	conns := []*net.UDPConn{qconn}
+	if dopts.Interface != nil {
+		if err := ipv4.NewPacketConn(qconn).SetMulticastInterface(dopts.Interface); err != nil {
+			qconn.Close()
+			return nil, err
+		}
+	}
+	if mconn, err := net.ListenMulticastUDP("udp4", dopts.Interface, group); err == nil {
+		conns = append(conns, mconn)
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	query, err := mdnsQuery()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			qconn.WriteToUDP(query, group)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	type packet struct {
+		buf []byte
+		src net.IP
+	}
+	packets := make(chan packet)
+	for _, conn := range conns {
+		go func(conn *net.UDPConn) {
+			buf := make([]byte, 9000)
+			for {
+				n, src, err := conn.ReadFromUDP(buf)
+				if err != nil {
+					return // closed
+				}
+				select {
+				case packets <- packet{buf: append([]byte(nil), buf[:n]...), src: src.IP}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(conn)
+	}
+
+	b := newMdnsBrowser()
+	for {
+		select {
+		case p := <-packets:
+			b.add(p.buf, p.src)
+			if dopts.Serial != "" && b.has(dopts.Serial) {
+				return b.devices(), nil
+			}
+		case <-ctx.Done():
+			return b.devices(), nil
+		}
+	}
+}
+
+// mdnsQuery returns a PTR query for DiscoveryService
+func mdnsQuery() ([]byte, error) {
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(DiscoveryService),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	return msg.Pack()
+}
+
+// mdnsBrowser collects the records of mDNS responses
+type mdnsBrowser struct {
+	instances map[string]string   // instance names found via PTR, lower case -> as sent
+	srv       map[string]*net.SRV // instance -> srv record
+	hosts     map[string]net.IP   // hostname -> address
+	sources   map[string]net.IP   // instance -> source address of the response
+	found     map[string]*DiscoveredDevice
+}
+
+func newMdnsBrowser() *mdnsBrowser {
+	return &mdnsBrowser{
+		instances: make(map[string]string),
+		srv:       make(map[string]*net.SRV),
+		hosts:     make(map[string]net.IP),
+		sources:   make(map[string]net.IP),
+		found:     make(map[string]*DiscoveredDevice),
+	}
+}
+
+// add parses a response and updates the found devices
+func (b *mdnsBrowser) add(packet []byte, src net.IP) {
+	msg := dnsmessage.Message{}
+	if err := msg.Unpack(packet); err != nil || !msg.Header.Response {
+		return
+	}
+	records := append(msg.Answers, msg.Additionals...)
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == DiscoveryService {
+				instance := strings.ToLower(body.PTR.String())
+				b.instances[instance] = body.PTR.String()
+				b.sources[instance] = src
+			}
+		case *dnsmessage.SRVResource:
+			b.srv[name] = &net.SRV{Target: strings.ToLower(body.Target.String()), Port: body.Port}
+		case *dnsmessage.AResource:
+			b.hosts[name] = net.IP(body.A[:])
+		}
+	}
+
+	for instance, original := range b.instances {
+		srv, ok := b.srv[instance]
+		if !ok {
+			continue
+		}
+		ip, ok := b.hosts[srv.Target]
+		if !ok {
+			ip = b.sources[instance]
+		}
+		d := parseInstanceName(trimServiceSuffix(original))
+		d.Address = net.JoinHostPort(ip.String(), fmt.Sprintf("%d", srv.Port))
+		b.found[d.Serial] = d
+	}
+}
+
+// has returns true if the device with given serial was found
+func (b *mdnsBrowser) has(serial string) bool {
+	_, ok := b.found[strings.ToUpper(serial)]
+	return ok
+}
+
+// devices returns all found devices, sorted by serial
+func (b *mdnsBrowser) devices() []*DiscoveredDevice {
+	devices := make([]*DiscoveredDevice, 0, len(b.found))
+	for _, d := range b.found {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Serial < devices[j].Serial })
+	return devices
+}
+
+// trimServiceSuffix removes DiscoveryService from an instance name
+func trimServiceSuffix(instance string) string {
+	suffix := "." + DiscoveryService
+	if len(instance) > len(suffix) && strings.EqualFold(instance[len(instance)-len(suffix):], suffix) {
+		return instance[:len(instance)-len(suffix)]
+	}
+	return instance
+}
+
+// parseInstanceName parses instance names in the `<type>_<serial>` format
+func parseInstanceName(name string) *DiscoveredDevice {
+	d := &DiscoveredDevice{Name: name, Serial: strings.ToUpper(name)}
+	if i := strings.Index(name, "_"); i > 0 {
+		d.Model = strings.ToUpper(name[:i])
+		d.Serial = strings.ToUpper(name[i+1:])
+	}
+	return d
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+	"github.com/adrian-bl/dyslink/lib/dyslink/sim"
+)
+
+func TestDiscoverWithOpts(t *testing.T) {
+	// a private port keeps us away from the mDNS responder of the host
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 15353}
+	fan := sim.NewFan(&sim.Opts{Model: dyslink.TypeModelN438, Serial: "NN4-CH-HEA0322B"})
+	broker := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1883}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fan.Advertise(ctx, &sim.AdvertiseOpts{Address: broker, GroupAddr: group})
+	}()
+	select {
+	case err := <-errc:
+		t.Skipf("multicast is not available: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	dctx, dcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer dcancel()
+	start := time.Now()
+	devices, err := dyslink.DiscoverWithOpts(dctx, &dyslink.DiscoverOpts{
+		GroupAddr: group,
+		Interval:  100 * time.Millisecond,
+		Serial:    "nn4-ch-hea0322b",
+	})
+	if err != nil {
+		t.Fatalf("DiscoverWithOpts() failed: %v", err)
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Errorf("DiscoverWithOpts() did not return once the device was found")
+	}
+	if len(devices) != 1 {
+		t.Fatalf("DiscoverWithOpts() = %v, want a single device", devices)
+	}
+	want := dyslink.DiscoveredDevice{
+		Name:    "438_NN4-CH-HEA0322B",
+		Serial:  "NN4-CH-HEA0322B",
+		Model:   dyslink.TypeModelN438,
+		Address: "127.0.0.1:1883",
+	}
+	if *devices[0] != want {
+		t.Errorf("DiscoverWithOpts() = %+v, want %+v", devices[0], want)
+	}
+	if got := devices[0].DeviceAddress(); got != "tcp://127.0.0.1:1883" {
+		t.Errorf("DeviceAddress() = %s", got)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind identifies the type of an Event.
+// Messages with a custom decoder use their command as kind.
+type EventKind string
+
+const (
+	EventCurrentState EventKind = MessageCurrentState      // Payload is *ProductState
+	EventStateChange  EventKind = MessageStateChange       // Payload is *StateChange
+	EventEnvironment  EventKind = MessageEnvSensorData     // Payload is *EnvironmentState
+	EventFaults       EventKind = MessageCurrentFaults     // Payload is *DeviceFaults
+	EventCredentials  EventKind = MessageDeviceCredentials // Payload is *DeviceCredentials
+	EventConnection   EventKind = "CONNECTION"             // Payload is *ConnectionEvent
+	EventRaw          EventKind = "RAW"                    // Payload is *RawMessage
+)
+
+// Event is a message received from the device or a change of the connection state
+type Event struct {
+	Kind       EventKind
+	Command    string      // The `msg` field of the message, empty for connection events
+	Device     string      // The device which sent the message
+	DeviceTime time.Time   // The `time` field of the message, zero if missing
+	ReceivedAt time.Time   // The time we received the message
+	Payload    interface{} // The decoded message, see EventKind
+	Error      error       // Set if the message could not be decoded
+}
+
+// EventHandler is a callback registered via Client.OnEvent
+type EventHandler func(*Event)
+
+// ProductState returns the payload of EventCurrentState events and
+// the new state of EventStateChange events
+func (e *Event) ProductState() (*ProductState, bool) {
+	if sc, ok := e.Payload.(*StateChange); ok && sc != nil {
+		return sc.Current, true
+	}
+	v, ok := e.Payload.(*ProductState)
+	return v, ok
+}
+
+// StateChange returns the payload of EventStateChange events
+func (e *Event) StateChange() (*StateChange, bool) {
+	v, ok := e.Payload.(*StateChange)
+	return v, ok
+}
+
+// Environment returns the payload of EventEnvironment events
+func (e *Event) Environment() (*EnvironmentState, bool) {
+	v, ok := e.Payload.(*EnvironmentState)
+	return v, ok
+}
+
+// Faults returns the payload of EventFaults events
+func (e *Event) Faults() (*DeviceFaults, bool) {
+	v, ok := e.Payload.(*DeviceFaults)
+	return v, ok
+}
+
+// Credentials returns the payload of EventCredentials events
+func (e *Event) Credentials() (*DeviceCredentials, bool) {
+	v, ok := e.Payload.(*DeviceCredentials)
+	return v, ok
+}
+
+// Connection returns the payload of EventConnection events
+func (e *Event) Connection() (*ConnectionEvent, bool) {
+	v, ok := e.Payload.(*ConnectionEvent)
+	return v, ok
+}
+
+// Raw returns the payload of EventRaw events
+func (e *Event) Raw() (*RawMessage, bool) {
+	v, ok := e.Payload.(*RawMessage)
+	return v, ok
+}
+
+// OnEvent registers a callback for events of given kind.
+// Callbacks are called synchronously and must not block.
+func (c *client) OnEvent(kind EventKind, h EventHandler) {
+	c.mu.Lock()
+	c.handlers[kind] = append(c.handlers[kind], h)
+	c.mu.Unlock()
+}
+
+// dispatch delivers an event to all waiters, callbacks and channels
+func (c *client) dispatch(ev *Event) {
+	ev.Device = c.opts.Username
+	ev.ReceivedAt = time.Now()
+
+	if ev.Error == nil && ev.Command != "" {
+		c.notifyWaiters(ev.Command, ev.Payload)
+	}
+
+	c.mu.Lock()
+	handlers := c.handlers[ev.Kind]
+	c.mu.Unlock()
+	for _, h := range handlers {
+		h(ev)
+	}
+
+	if c.sink != nil {
+		c.sink(ev)
+	}
+	// the channels must not block the receive path: drop events if they are full
+	if c.opts.EventChan != nil {
+		select {
+		case c.opts.EventChan <- ev:
+		default:
+			c.dropEvent("event channel", ev)
+		}
+	}
+	if c.opts.CallbackChan != nil {
+		if cb := messageCallback(ev); cb != nil {
+			select {
+			case c.opts.CallbackChan <- cb:
+			default:
+				c.dropEvent("callback channel", ev)
+			}
+		}
+	}
+}
+
+// messageCallback returns the MessageCallback of an event or nil
+// if the event is of a kind which was never sent to the CallbackChan
+func messageCallback(ev *Event) *MessageCallback {
+	switch ev.Kind {
+	case EventCurrentState, EventEnvironment, EventCredentials:
+		return &MessageCallback{Error: ev.Error, Message: ev.Payload}
+	case EventStateChange:
+		// MessageCallback predates StateChange and only carries the new state
+		var msg interface{}
+		if sc, ok := ev.Payload.(*StateChange); ok && sc != nil {
+			msg = sc.Current
+		}
+		return &MessageCallback{Error: ev.Error, Message: msg}
+	}
+	return nil
+}
+
+// dropEvent counts and logs an event which could not be delivered to given channel
+func (c *client) dropEvent(channel string, ev *Event) {
+	n := atomic.AddUint64(&c.dropped, 1)
+	c.log(LevelWarn, fmt.Sprintf("%s full, dropped %s event (%d in total)", channel, ev.Kind, n), nil)
+}
+
+// Dropped returns the number of events dropped because the
+// EventChan or CallbackChan was not consumed in time
+func (c *client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// connectionEvent dispatches a change of the connection state
+func (c *client) connectionEvent(state string, err error) {
+	c.dispatch(&Event{Kind: EventConnection, Payload: &ConnectionEvent{State: state, Error: err}})
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCallbackChanKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string // empty for a connection event
+		wantType interface{}
+	}{
+		{"current state", testCurrentState, &ProductState{}},
+		{"state change", `{"msg":"STATE-CHANGE","product-state":{"fmod":["OFF","FAN"]}}`, &ProductState{}},
+		{"environment", testEnvironment, &EnvironmentState{}},
+		{"credentials", `{"msg":"DEVICE-CREDENTIALS","serialNumber":"NN4-CH-HEA0322B","apPasswordHash":"secret"}`, &DeviceCredentials{}},
+		{"faults", `{"msg":"CURRENT-FAULTS","product-errors":{"amf1":"FAIL"}}`, nil},
+		{"raw", `{"msg":"SOMETHING-NEW"}`, nil},
+		{"connection", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := make(chan *MessageCallback, 1)
+			events := make(chan *Event, 1)
+			c := NewClient(&ClientOpts{Username: testSerial, Model: TypeModelN475, CallbackChan: cb, EventChan: events}).(*client)
+			if tt.payload == "" {
+				c.connectionEvent(ConnectionConnected, nil)
+			} else {
+				c.dispatch(decodeMessage([]byte(tt.payload)))
+			}
+
+			if len(events) != 1 {
+				t.Errorf("EventChan received %d events, want 1", len(events))
+			}
+			select {
+			case m := <-cb:
+				if tt.wantType == nil {
+					t.Fatalf("CallbackChan received %T, want nothing", m.Message)
+				}
+				if reflect.TypeOf(m.Message) != reflect.TypeOf(tt.wantType) || m.Error != nil {
+					t.Errorf("CallbackChan received %T (%v), want %T", m.Message, m.Error, tt.wantType)
+				}
+			default:
+				if tt.wantType != nil {
+					t.Errorf("CallbackChan received nothing, want %T", tt.wantType)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchDropsWhenFull(t *testing.T) {
+	events := make(chan *Event)
+	cb := make(chan *MessageCallback)
+	tr := NewMemoryTransport()
+	tr.OnPublish = func(topic string, payload []byte) {
+		tr.Deliver(testStatusTopic, []byte(testCurrentState))
+	}
+	c := NewClient(&ClientOpts{Username: testSerial, Model: TypeModelN475, Transport: tr, EventChan: events, CallbackChan: cb})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+
+	// nobody reads the channels: the reply must still reach GetState
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.GetState(ctx); err != nil {
+		t.Fatalf("GetState() with full channels failed: %v", err)
+	}
+	// at least the CURRENT-STATE was dropped by both channels
+	if n := c.Dropped(); n < 2 {
+		t.Errorf("Dropped() = %d, want at least 2", n)
+	}
+}
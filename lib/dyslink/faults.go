@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity of a DeviceFault
+type FaultSeverity int
+
+const (
+	SeverityWarning FaultSeverity = iota
+	SeverityError
+)
+
+func (s FaultSeverity) String() string {
+	if s == SeverityError {
+		return "ERROR"
+	}
+	return "WARNING"
+}
+
+// Sources of a DeviceFault
+const (
+	FaultSourceProduct = "product" // the fan itself
+	FaultSourceModule  = "module"  // the wifi module
+)
+
+// DeviceFault is a fault or warning reported by the device
+type DeviceFault struct {
+	Code        string // Code as sent by the device, eg. `fltr`
+	Source      string // One of the FaultSource* constants
+	Severity    FaultSeverity
+	Description string
+}
+
+func (f DeviceFault) String() string {
+	return fmt.Sprintf("%s %s/%s: %s", f.Severity, f.Source, f.Code, f.Description)
+}
+
+// DeviceFaults is the decoded reply to RequestFaults or the decoded
+// error and warning code of a ProductState.
+// Only codes which are not reported as `OK` are included.
+type DeviceFaults struct {
+	Faults []DeviceFault
+}
+
+// HasErrors returns true if at least one fault has SeverityError
+func (df *DeviceFaults) HasErrors() bool {
+	for _, f := range df.Faults {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// faultDescriptions holds the known fault codes
+var faultDescriptions = map[string]string{
+	"fltr": "filter needs replacing",
+	"tilt": "device is tilted or tipped over",
+	"amf1": "motor fault",
+	"amf2": "motor fault",
+	"amf3": "motor fault",
+	"amf4": "motor fault",
+	"amf5": "motor fault",
+	"amf6": "motor fault",
+	"amf7": "motor fault",
+	"ibus": "internal communication fault",
+	"dmot": "damper motor fault",
+	"temp": "temperature sensor fault",
+	"humi": "humidity sensor fault",
+	"pm25": "PM2.5 sensor fault",
+	"pm10": "PM10 sensor fault",
+	"sen1": "air quality sensor fault",
+	"sen2": "air quality sensor fault",
+	"sen3": "air quality sensor fault",
+	"nwcs": "wifi: network connection failed",
+	"stac": "wifi: station connection failed",
+	"strs": "wifi: weak signal",
+	"srnk": "wifi: cloud server not reachable",
+	"srmi": "wifi: cloud message receive failed",
+	"srmu": "wifi: cloud message upload failed",
+	"wpmp": "water pump fault",
+}
+
+// newDeviceFault returns a DeviceFault for given code
+func newDeviceFault(code, source string, severity FaultSeverity) DeviceFault {
+	desc, ok := faultDescriptions[code]
+	if !ok {
+		desc = fmt.Sprintf("unknown fault %q", code)
+	}
+	return DeviceFault{Code: code, Source: source, Severity: severity, Description: desc}
+}
+
+// Faults decodes the error and warning code of the product state.
+// The codes are matched case-insensitively against the codes of
+// CURRENT-FAULTS messages; other codes (eg. numeric ones) are included
+// with a generic description. Use RequestFaults to get all faults.
+func (p *ProductState) Faults() *DeviceFaults {
+	df := &DeviceFaults{}
+	add := func(code string, severity FaultSeverity) {
+		if code == "" || code == "NONE" {
+			return
+		}
+		if _, ok := faultDescriptions[strings.ToLower(code)]; ok {
+			code = strings.ToLower(code)
+		}
+		df.Faults = append(df.Faults, newDeviceFault(code, FaultSourceProduct, severity))
+	}
+	add(p.ErrorCode, SeverityError)
+	add(p.WarningCode, SeverityWarning)
+	return df
+}
+
+// The json of a CURRENT-FAULTS message
+type faultsPayload struct {
+	ProductErrors   map[string]string `json:"product-errors"`
+	ProductWarnings map[string]string `json:"product-warnings"`
+	ModuleErrors    map[string]string `json:"module-errors"`
+	ModuleWarnings  map[string]string `json:"module-warnings"`
+}
+
+// parseFaultsPayload decodes a CURRENT-FAULTS message
+func parseFaultsPayload(payload []byte) (*DeviceFaults, error) {
+	fp := &faultsPayload{}
+	if err := json.Unmarshal(payload, fp); err != nil {
+		return nil, err
+	}
+
+	df := &DeviceFaults{}
+	add := func(m map[string]string, source string, severity FaultSeverity) {
+		codes := make([]string, 0, len(m))
+		for code := range m {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			if m[code] != "OK" {
+				df.Faults = append(df.Faults, newDeviceFault(code, source, severity))
+			}
+		}
+	}
+	add(fp.ProductErrors, FaultSourceProduct, SeverityError)
+	add(fp.ProductWarnings, FaultSourceProduct, SeverityWarning)
+	add(fp.ModuleErrors, FaultSourceModule, SeverityError)
+	add(fp.ModuleWarnings, FaultSourceModule, SeverityWarning)
+	return df, nil
+}
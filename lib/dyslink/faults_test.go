@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFaultsPayload(t *testing.T) {
+	payload := `{"msg":"CURRENT-FAULTS","time":"2019-03-17T12:00:00.000Z",` +
+		`"product-errors":{"tilt":"OK","amf1":"FAIL","temp":"OK"},` +
+		`"product-warnings":{"fltr":"FAIL"},` +
+		`"module-errors":{"xyzw":"FAIL"},` +
+		`"module-warnings":{"strs":"OK"}}`
+
+	ev := decodeMessage([]byte(payload))
+	if ev.Kind != EventFaults || ev.Error != nil {
+		t.Fatalf("decodeMessage() = %+v, want a faults event", ev)
+	}
+	df, ok := ev.Faults()
+	if !ok || df == nil {
+		t.Fatalf("Faults() = %v, %v", df, ok)
+	}
+	want := []DeviceFault{
+		{Code: "amf1", Source: FaultSourceProduct, Severity: SeverityError, Description: "motor fault"},
+		{Code: "fltr", Source: FaultSourceProduct, Severity: SeverityWarning, Description: "filter needs replacing"},
+		{Code: "xyzw", Source: FaultSourceModule, Severity: SeverityError, Description: `unknown fault "xyzw"`},
+	}
+	if !reflect.DeepEqual(df.Faults, want) {
+		t.Errorf("Faults = %+v, want %+v", df.Faults, want)
+	}
+	if !df.HasErrors() {
+		t.Errorf("HasErrors() = false, want true")
+	}
+}
+
+func TestProductStateFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		state ProductState
+		want  []DeviceFault
+	}{
+		{
+			name:  "no faults",
+			state: ProductState{ErrorCode: "NONE", WarningCode: "NONE"},
+		},
+		{
+			name: "not reported",
+		},
+		{
+			name:  "known warning",
+			state: ProductState{ErrorCode: "NONE", WarningCode: "FLTR"},
+			want: []DeviceFault{
+				{Code: "fltr", Source: FaultSourceProduct, Severity: SeverityWarning, Description: "filter needs replacing"},
+			},
+		},
+		{
+			name:  "known error and warning",
+			state: ProductState{ErrorCode: "amf1", WarningCode: "tilt"},
+			want: []DeviceFault{
+				{Code: "amf1", Source: FaultSourceProduct, Severity: SeverityError, Description: "motor fault"},
+				{Code: "tilt", Source: FaultSourceProduct, Severity: SeverityWarning, Description: "device is tilted or tipped over"},
+			},
+		},
+		{
+			name:  "numeric error",
+			state: ProductState{ErrorCode: "02C9", WarningCode: "NONE"},
+			want: []DeviceFault{
+				{Code: "02C9", Source: FaultSourceProduct, Severity: SeverityError, Description: `unknown fault "02C9"`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df := tt.state.Faults()
+			if !reflect.DeepEqual(df.Faults, tt.want) {
+				t.Errorf("Faults() = %+v, want %+v", df.Faults, tt.want)
+			}
+			if df.HasErrors() != (tt.state.ErrorCode != "" && tt.state.ErrorCode != "NONE") {
+				t.Errorf("HasErrors() = %v", df.HasErrors())
+			}
+		})
+	}
+}
+
+func TestDecodeCurrentStateFaults(t *testing.T) {
+	payload := `{"msg":"CURRENT-STATE","product-state":{"fmod":"FAN","ercd":"NONE","wacd":"FLTR"}}`
+	ev := decodeMessage([]byte(payload))
+	ps, ok := ev.ProductState()
+	if !ok || ps == nil {
+		t.Fatalf("ProductState() = %v, %v", ps, ok)
+	}
+	if ps.ErrorCode != "NONE" || ps.WarningCode != "FLTR" {
+		t.Fatalf("ErrorCode, WarningCode = %q, %q", ps.ErrorCode, ps.WarningCode)
+	}
+	if df := ps.Faults(); len(df.Faults) != 1 || df.Faults[0].Code != "fltr" || df.HasErrors() {
+		t.Errorf("Faults() = %+v", df)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity of a log entry
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL%d", int(l))
+}
+
+// Fields are structured key/value pairs attached to a log entry
+type Fields map[string]interface{}
+
+// Names of the fields set by the library
+const (
+	FieldDevice    = "device"    // serial of the device
+	FieldTopic     = "topic"     // mqtt topic of a message
+	FieldDirection = "direction" // `in` or `out`
+	FieldComponent = "component" // `mqtt` for log entries of the mqtt library
+)
+
+// Logger receives the log output of the library.
+// Set ClientOpts.Logger to install one.
+type Logger interface {
+	Log(level LogLevel, msg string, fields Fields)
+}
+
+// NewWriterLogger returns a Logger writing all entries with
+// at least minLevel as plain text lines to w
+func NewWriterLogger(w io.Writer, minLevel LogLevel) Logger {
+	return &writerLogger{w: w, minLevel: minLevel}
+}
+
+type writerLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel LogLevel
+}
+
+func (l *writerLogger) Log(level LogLevel, msg string, fields Fields) {
+	if level < l.minLevel {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %-5s %s", time.Now().Format(time.RFC3339), level, msg)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%v", k, fields[k])
+	}
+	sb.WriteString("\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, sb.String())
+}
+
+// nopLogger discards all log entries
+type nopLogger struct{}
+
+func (nopLogger) Log(LogLevel, string, Fields) {}
+
+// pahoLogger adapts a Logger to the logging interface of the mqtt library
+type pahoLogger struct {
+	l     Logger
+	level LogLevel
+}
+
+func (p pahoLogger) Println(v ...interface{}) {
+	p.l.Log(p.level, strings.TrimSuffix(fmt.Sprintln(v...), "\n"), Fields{FieldComponent: "mqtt"})
+}
+
+func (p pahoLogger) Printf(format string, v ...interface{}) {
+	p.l.Log(p.level, fmt.Sprintf(format, v...), Fields{FieldComponent: "mqtt"})
+}
+
+// mqttLoggerOnce makes sure that the mqtt logger is installed only once
+var mqttLoggerOnce sync.Once
+
+// SetMqttLogger routes the output of the mqtt library to given logger,
+// a nil logger discards it. The mqtt library only supports process-wide
+// loggers, so this affects all clients and should be called before
+// connecting any of them.
+// If it is never called, the logger of the first client connecting with
+// a Logger (or Debug) set is installed.
+func SetMqttLogger(l Logger) {
+	mqttLoggerOnce.Do(func() {})
+	setMqttLogger(l)
+}
+
+// installMqttLogger installs given logger unless a logger was installed before
+func installMqttLogger(l Logger) {
+	mqttLoggerOnce.Do(func() { setMqttLogger(l) })
+}
+
+func setMqttLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	mqtt.ERROR = pahoLogger{l: l, level: LevelError}
+	mqtt.CRITICAL = pahoLogger{l: l, level: LevelError}
+	mqtt.WARN = pahoLogger{l: l, level: LevelWarn}
+	mqtt.DEBUG = pahoLogger{l: l, level: LevelDebug}
+}
+
+// log writes a log entry tagged with our device
+func (c *client) log(level LogLevel, msg string, fields Fields) {
+	if fields == nil {
+		fields = Fields{}
+	}
+	fields[FieldDevice] = c.opts.Username
+	c.logger.Log(level, msg, fields)
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ManagerEventBuffer is the number of events buffered by a Manager
+const ManagerEventBuffer = 64
+
+// Manager maintains connections to multiple devices, keyed by serial,
+// and merges their events into a single stream.
+type Manager struct {
+	dropped uint64 // accessed atomically, first field to keep it 64-bit aligned
+	mu      sync.Mutex
+	devices map[string]*Device
+	events  chan *Event
+}
+
+// Device is a device managed by a Manager. It caches the
+// last state, sensor data and faults reported by the device.
+type Device struct {
+	Serial string
+	Model  string
+	Client Client
+
+	mu          sync.Mutex
+	state       *ProductState
+	environment *EnvironmentState
+	faults      *DeviceFaults
+	connected   bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager returns a new manager. The events of all devices are
+// delivered to Events(). Events are dropped (see Dropped) instead of
+// blocking the devices once ManagerEventBuffer events are pending.
+func NewManager() *Manager {
+	return &Manager{
+		devices: make(map[string]*Device),
+		events:  make(chan *Event, ManagerEventBuffer),
+	}
+}
+
+// Events returns the merged event stream of all devices.
+// Event.Device identifies the sending device.
+func (m *Manager) Events() <-chan *Event {
+	return m.events
+}
+
+// Dropped returns the number of events dropped because Events() was not consumed in time
+func (m *Manager) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// sink returns the event sink of given client: it must not block, as
+// it is called from the mqtt library, which would stall all devices.
+func (m *Manager) sink(c *client) func(*Event) {
+	return func(ev *Event) {
+		select {
+		case m.events <- ev:
+		default:
+			n := atomic.AddUint64(&m.dropped, 1)
+			c.log(LevelWarn, fmt.Sprintf("event stream full, dropped %s event (%d in total)", ev.Kind, n), nil)
+		}
+	}
+}
+
+// Add starts managing the device described by opts, using opts.Username as serial.
+// The connection is established in the background and retried until it succeeds,
+// failed attempts are reported as EventConnection events.
+func (m *Manager) Add(opts *ClientOpts) (*Device, error) {
+	if opts.Username == "" {
+		return nil, fmt.Errorf("no serial (username) given")
+	}
+
+	// The manager owns the event stream of its clients
+	copts := *opts
+	copts.EventChan = nil
+	copts.CallbackChan = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Device{
+		Serial: copts.Username,
+		Model:  copts.Model,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	c := NewClient(&copts).(*client)
+	c.sink = m.sink(c)
+	c.OnEvent(EventCurrentState, d.updateState)
+	c.OnEvent(EventStateChange, d.updateState)
+	c.OnEvent(EventEnvironment, d.updateEnvironment)
+	c.OnEvent(EventFaults, d.updateFaults)
+	c.OnEvent(EventConnection, d.updateConnection)
+	d.Client = c
+
+	m.mu.Lock()
+	if _, ok := m.devices[d.Serial]; ok {
+		m.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("device %s already added", d.Serial)
+	}
+	m.devices[d.Serial] = d
+	m.mu.Unlock()
+
+	go d.run(ctx, c)
+	return d, nil
+}
+
+// Remove disconnects and forgets the device with given serial
+func (m *Manager) Remove(serial string) error {
+	m.mu.Lock()
+	d, ok := m.devices[serial]
+	delete(m.devices, serial)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("device %s not found", serial)
+	}
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+// Device returns the device with given serial
+func (m *Manager) Device(serial string) (*Device, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.devices[serial]
+	return d, ok
+}
+
+// Devices returns all managed devices, sorted by serial
+func (m *Manager) Devices() []*Device {
+	m.mu.Lock()
+	devices := make([]*Device, 0, len(m.devices))
+	for _, d := range m.devices {
+		devices = append(devices, d)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Serial < devices[j].Serial })
+	return devices
+}
+
+// Close removes all devices
+func (m *Manager) Close() {
+	for _, d := range m.Devices() {
+		m.Remove(d.Serial)
+	}
+}
+
+// run connects the client, retrying with a backoff until it succeeds,
+// and disconnects it once the context is cancelled. Reconnects after
+// an established connection are handled by the client itself.
+func (d *Device) run(ctx context.Context, c *client) {
+	defer close(d.done)
+
+	maxBackoff := c.opts.MaxReconnectInterval
+	if maxBackoff == 0 {
+		maxBackoff = DefaultMaxReconnectInterval
+	}
+	backoff := time.Second
+	for {
+		err := c.ConnectContext(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.log(LevelWarn, fmt.Sprintf("connect failed, retrying in %s: %v", backoff, err), nil)
+		c.connectionEvent(ConnectionDisconnected, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		c.connectionEvent(ConnectionReconnecting, nil)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	c.RequestCurrentStateContext(ctx)
+	c.RequestFaultsContext(ctx)
+	<-ctx.Done()
+	c.Disconnect(250)
+}
+
+// State returns the last reported state, nil if unknown
+func (d *Device) State() *ProductState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// Environment returns the last reported sensor data, nil if unknown
+func (d *Device) Environment() *EnvironmentState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.environment
+}
+
+// Faults returns the last reported faults, nil if unknown
+func (d *Device) Faults() *DeviceFaults {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.faults
+}
+
+// Connected returns true if the device is currently connected
+func (d *Device) Connected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connected
+}
+
+func (d *Device) updateState(ev *Event) {
+	if ps, ok := ev.ProductState(); ok && ps != nil {
+		d.mu.Lock()
+		d.state = ps
+		d.mu.Unlock()
+	}
+}
+
+func (d *Device) updateEnvironment(ev *Event) {
+	if env, ok := ev.Environment(); ok && env != nil {
+		d.mu.Lock()
+		d.environment = env
+		d.mu.Unlock()
+	}
+}
+
+func (d *Device) updateFaults(ev *Event) {
+	if f, ok := ev.Faults(); ok && f != nil {
+		d.mu.Lock()
+		d.faults = f
+		d.mu.Unlock()
+	}
+}
+
+func (d *Device) updateConnection(ev *Event) {
+	if ce, ok := ev.Connection(); ok {
+		d.mu.Lock()
+		d.connected = ce.State == ConnectionConnected
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it returns true or a second passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerDropsEventsInsteadOfBlocking(t *testing.T) {
+	m := NewManager()
+	tr := NewMemoryTransport()
+	d, err := m.Add(&ClientOpts{Username: "NN4-CH-HEA0322B", Model: TypeModelN475, Transport: tr})
+	if err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	waitFor(t, "the connection", d.Connected)
+
+	// nobody consumes m.Events(): delivering must neither block nor lose the cached state
+	topic := "475/NN4-CH-HEA0322B/status/current"
+	env := []byte(`{"msg":"ENVIRONMENTAL-CURRENT-SENSOR-DATA","time":"2019-03-17T12:00:00.000Z","data":{"tact":"2950"}}`)
+	delivered := make(chan struct{})
+	go func() {
+		for i := 0; i < ManagerEventBuffer+10; i++ {
+			tr.Deliver(topic, env)
+		}
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatalf("delivering events blocked")
+	}
+	if m.Dropped() < 10 {
+		t.Errorf("Dropped() = %d, want at least 10", m.Dropped())
+	}
+	if e := d.Environment(); e == nil || e.Temperature != "2950" {
+		t.Errorf("Environment() = %+v", e)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Close() blocked")
+	}
+	if len(m.Devices()) != 0 {
+		t.Errorf("Devices() = %v after Close()", m.Devices())
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMessage is a message published via a MemoryTransport
+type MemoryMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// MemoryTransport is an in-memory Transport, intended for tests:
+// Messages published by the client are recorded (and passed to OnPublish),
+// messages of the device are injected via Deliver.
+type MemoryTransport struct {
+	OnPublish  func(topic string, payload []byte) // Called for each message published by the client, may call Deliver
+	ConnectErr error                              // Returned by Connect if set
+
+	mu        sync.Mutex
+	handlers  *TransportHandlers
+	connected bool
+	subs      map[string]bool
+	published []*MemoryMessage
+}
+
+// NewMemoryTransport returns a new, unconnected, in-memory transport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{subs: make(map[string]bool)}
+}
+
+func (t *MemoryTransport) Connect(ctx context.Context, h *TransportHandlers) error {
+	if t.ConnectErr != nil {
+		return t.ConnectErr
+	}
+	t.mu.Lock()
+	t.handlers = h
+	t.connected = true
+	t.subs = make(map[string]bool)
+	t.mu.Unlock()
+
+	go h.Connected() // like paho, which does not block Connect on the handler
+	return nil
+}
+
+func (t *MemoryTransport) Disconnect(quiesce uint) {
+	t.mu.Lock()
+	t.connected = false
+	t.mu.Unlock()
+}
+
+func (t *MemoryTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	t.mu.Lock()
+	if !t.connected {
+		t.mu.Unlock()
+		return ErrNotConnected
+	}
+	t.published = append(t.published, &MemoryMessage{Topic: topic, Payload: payload})
+	cb := t.OnPublish
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(topic, payload)
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Subscribe(ctx context.Context, topic string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.connected {
+		return ErrNotConnected
+	}
+	t.subs[topic] = true
+	return nil
+}
+
+// Deliver passes a message to the client as if it was sent by the device.
+// Returns false if the client is not connected or not subscribed to topic.
+func (t *MemoryTransport) Deliver(topic string, payload []byte) bool {
+	t.mu.Lock()
+	ok := t.connected && t.subs[topic]
+	h := t.handlers
+	t.mu.Unlock()
+
+	if ok {
+		h.Message(topic, payload)
+	}
+	return ok
+}
+
+// Published returns all messages published by the client so far
+func (t *MemoryTransport) Published() []*MemoryMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*MemoryMessage(nil), t.published...)
+}
+
+// Subscribed returns true if the client is subscribed to given topic
+func (t *MemoryTransport) Subscribed(topic string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.subs[topic]
+}
+
+// Drop simulates the loss of the connection, which drops all subscriptions.
+// Use Reconnect to restore the connection.
+func (t *MemoryTransport) Drop(err error) {
+	t.mu.Lock()
+	t.connected = false
+	t.subs = make(map[string]bool)
+	h := t.handlers
+	t.mu.Unlock()
+
+	if h != nil {
+		h.ConnectionLost(err)
+	}
+}
+
+// Reconnect simulates a successful reconnect after Drop
+func (t *MemoryTransport) Reconnect() {
+	t.mu.Lock()
+	t.connected = true
+	h := t.handlers
+	t.mu.Unlock()
+
+	if h != nil {
+		go h.Connected()
+	}
+}
@@ -10,10 +10,24 @@
 
 package dyslink
 
+import (
+	"time"
+)
+
 const (
-	TypeModelN475 = "475" // pure link cool (non-desk)
-	TypeModelN469 = "469" // pure link cool round/desk
-	TypeModelN455 = "455" // pure hot & cool
+	TypeModelN475  = "475"  // pure link cool (non-desk)
+	TypeModelN469  = "469"  // pure link cool round/desk
+	TypeModelN455  = "455"  // pure hot & cool
+	TypeModelN438  = "438"  // pure cool tower
+	TypeModelN520  = "520"  // pure cool desk
+	TypeModelN527  = "527"  // pure hot & cool (2018)
+	TypeModelN358  = "358"  // pure humidify & cool
+	TypeModelN438E = "438E" // purifier cool
+	TypeModelN527E = "527E" // purifier hot & cool
+	TypeModelN358E = "358E" // purifier humidify & cool
+	TypeModelN438K = "438K" // purifier cool formaldehyde
+	TypeModelN527K = "527K" // purifier hot & cool formaldehyde
+	TypeModelN358K = "358K" // purifier humidify & cool formaldehyde
 )
 
 type MessageCallback struct {
@@ -21,11 +35,36 @@ type MessageCallback struct {
 	Message interface{}
 }
 
+// States reported via ConnectionEvent
+const (
+	ConnectionConnected    = "CONNECTED"
+	ConnectionDisconnected = "DISCONNECTED"
+	ConnectionReconnecting = "RECONNECTING"
+)
+
+// ConnectionEvent is sent as EventConnection event
+// whenever the state of the connection to the device changes.
+type ConnectionEvent struct {
+	State string // One of the Connection* constants
+	Error error  // The reason for a disconnect, if any
+}
+
 type ClientOpts struct {
-	Username      string // The username to use for this connection
-	Password      string // The password to use for this connection
-	DeviceAddress string // The ip+port of the device in the tcp://IP:PORT format
-	Model         string // One of the TypeModel* constants
-	CallbackChan  chan<- *MessageCallback
-	Debug         bool
+	Username             string                  // The username to use for this connection
+	Password             string                  // The password to use for this connection
+	PasswordHash         string                  // The already hashed password, takes precedence over Password
+	DeviceAddress        string                  // The ip+port of the device in the tcp://IP:PORT format
+	Model                string                  // One of the TypeModel* constants
+	CallbackChan         chan<- *MessageCallback // Receives states, environment data and credentials, see also EventChan
+	EventChan            chan<- *Event           // Receives all messages as typed events, must be buffered
+	Debug                bool                    // Log at LevelDebug to stderr if no Logger is set
+	Logger               Logger                  // Receives the log output of the client, discarded if nil, see also SetMqttLogger
+	MaxReconnectInterval time.Duration           // Upper bound of the reconnect backoff, defaults to DefaultMaxReconnectInterval
+	Cloud                *CloudOpts              // Connect via the cloud instead of DeviceAddress if set, Username and Model are still required
+	Transport            Transport               // The transport to use instead of paho, see NewMemoryTransport and NewReplayTransport
+	Recorder             *Recorder               // Receives all messages sent and received, if set
 }
+
+// DefaultMaxReconnectInterval is the reconnect backoff limit used if
+// ClientOpts.MaxReconnectInterval is not set.
+const DefaultMaxReconnectInterval = time.Minute
@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Username used by unconfigured devices
+const provisionUsername = "initialconnection"
+
+// Errors reported via ProvisionError
+var (
+	ErrProvisionTimeout   = errors.New("device did not respond in time")
+	ErrWifiRejected       = errors.New("device could not join the wifi network")
+	ErrAlreadyProvisioned = errors.New("device is already provisioned")
+	ErrProvisionFailed    = errors.New("provisioning failed")
+)
+
+// Reasons sent in a PROVISIONING-FAILED message
+const (
+	provisionReasonWifi        = "WIFI-AUTH-FAILED"
+	provisionReasonProvisioned = "ALREADY-PROVISIONED"
+)
+
+// ProvisionError is returned by Provision. Use errors.Is to check
+// for one of the ErrProvision* / ErrWifiRejected / ErrAlreadyProvisioned
+// errors or the error of an expired context.
+type ProvisionError struct {
+	Step string // The command which failed, MessageDeviceCredentials if the device did not reply
+	Kind error  // One of the Err* errors above
+	Err  error  // The underlying error, if any
+}
+
+func (e *ProvisionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v: %v", e.Step, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Step, e.Kind)
+}
+
+func (e *ProvisionError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// ProvisionOpts configures Provision
+type ProvisionOpts struct {
+	Ssid     string // The wifi network to join
+	Password string // The password of the wifi network
+	DryRun   bool   // Only fetch the credentials: do not join a network and keep the access point open
+}
+
+// ProvisionResult are the credentials of a provisioned device
+type ProvisionResult struct {
+	Serial       string // The serial of the device, which is also its username
+	PasswordHash string // The (already hashed) password of the device
+}
+
+// Credentials returns the result as Credentials, ready to be saved
+func (r *ProvisionResult) Credentials(model string) *Credentials {
+	return &Credentials{Serial: r.Serial, PasswordHash: r.PasswordHash, Model: model}
+}
+
+// ProvisioningFailure is the decoded form of a PROVISIONING-FAILED message
+type ProvisioningFailure struct {
+	RequestId string `json:"requestId"`
+	Reason    string `json:"reason"`
+}
+
+func init() {
+	registerBuiltinDecoder(MessageProvisioningFailed, func(payload []byte) (interface{}, error) {
+		pf := &ProvisioningFailure{}
+		err := json.Unmarshal(payload, pf)
+		return pf, err
+	})
+}
+
+// Provision bootstraps an unconfigured device, which we must be connected to.
+// It tells the device to join the given network, waits for its DEVICE-CREDENTIALS
+// and closes the access point of the device afterwards.
+// The device does not confirm the single steps: a sent step was only acknowledged
+// by its broker. Rejected steps are reported by the device in a PROVISIONING-FAILED
+// message, a device which silently failed (eg. by leaving the access point to join
+// the wifi network) is reported as ErrProvisionTimeout.
+func (c *client) Provision(ctx context.Context, popts *ProvisionOpts) (*ProvisionResult, error) {
+	// unconfigured devices use a fixed username in their topics, regardless of the login
+	// we used for this connection
+	cmdTopic := c.getUserTopic(provisionUsername, "command")
+
+	ctx, cancel := replyContext(ctx)
+	defer cancel()
+
+	// first, subscribe to these special endpoints:
+	if err := c.subscribe(ctx, c.getUserTopic(provisionUsername, "credentials")); err != nil {
+		return nil, &ProvisionError{Step: "SUBSCRIBE", Kind: ErrProvisionFailed, Err: err}
+	}
+	credWaiter := c.addWaiter(MessageDeviceCredentials)
+	defer c.removeWaiter(credWaiter)
+	failWaiter := c.addWaiter(MessageProvisioningFailed)
+	defer c.removeWaiter(failWaiter)
+
+	// ..and assemble our commands:
+	steps := make(map[string]string) // requestId -> command
+	if !popts.DryRun {
+		cmd := &commandHeader{Command: MessageJoinNetwork, WifiSsid: popts.Ssid, WifiPassword: popts.Password, RequestId: randomHex(8)}
+		steps[cmd.RequestId] = cmd.Command
+		if err := c.provisionStep(ctx, cmdTopic, cmd); err != nil {
+			return nil, err
+		}
+	}
+	cmd := &commandHeader{Command: MessageAuthoriseUserRequest, RequestId: randomHex(8), Id: randomUUID()}
+	steps[cmd.RequestId] = cmd.Command
+	if err := c.provisionStep(ctx, cmdTopic, cmd); err != nil {
+		return nil, err
+	}
+
+	var result *ProvisionResult
+	select {
+	case msg := <-credWaiter.ch:
+		dc, ok := msg.(*DeviceCredentials)
+		if !ok {
+			return nil, &ProvisionError{Step: MessageDeviceCredentials, Kind: ErrProvisionFailed, Err: fmt.Errorf("unexpected payload %T", msg)}
+		}
+		result = &ProvisionResult{Serial: dc.SerialNumber, PasswordHash: dc.Password}
+	case msg := <-failWaiter.ch:
+		pf, ok := msg.(*ProvisioningFailure)
+		if !ok {
+			return nil, &ProvisionError{Step: MessageProvisioningFailed, Kind: ErrProvisionFailed, Err: fmt.Errorf("unexpected payload %T", msg)}
+		}
+		kind := ErrProvisionFailed
+		switch pf.Reason {
+		case provisionReasonWifi:
+			kind = ErrWifiRejected
+		case provisionReasonProvisioned:
+			kind = ErrAlreadyProvisioned
+		}
+		step, ok := steps[pf.RequestId]
+		if !ok {
+			step = MessageProvisioningFailed
+		}
+		return nil, &ProvisionError{Step: step, Kind: kind, Err: fmt.Errorf("device reported %s", pf.Reason)}
+	case <-ctx.Done():
+		err := ctx.Err()
+		if !popts.DryRun {
+			err = fmt.Errorf("%w (did the device fail to join %q?)", err, popts.Ssid)
+		}
+		return nil, &ProvisionError{Step: MessageDeviceCredentials, Kind: ErrProvisionTimeout, Err: err}
+	}
+
+	if !popts.DryRun {
+		if err := c.provisionStep(ctx, cmdTopic, &commandHeader{Command: MessageCloseAccessPoint}); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// provisionStep sends a command and waits until the broker of the device
+// acknowledged its delivery (the device itself does not reply)
+func (c *client) provisionStep(ctx context.Context, topic string, cmd *commandHeader) error {
+	err := c.publishCommand(ctx, topic, cmd)
+	if err == nil {
+		return nil
+	}
+	kind := ErrProvisionFailed
+	if ctx.Err() != nil {
+		kind = ErrProvisionTimeout
+	}
+	return &ProvisionError{Step: cmd.Command, Kind: kind, Err: err}
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// randomUUID returns a random (version 4) uuid
+func randomUUID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	buf[6] = (buf[6] & 0x0f) | 0x40
+	buf[8] = (buf[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(buf)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
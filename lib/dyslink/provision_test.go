@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+	"github.com/adrian-bl/dyslink/lib/dyslink/sim"
+)
+
+// startFan serves a simulated fan on a random local port and returns
+// a client connected to it. stop disconnects the client and stops the fan.
+func startFan(t *testing.T, opts *sim.Opts) (fan *sim.Fan, c dyslink.Client, stop func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	fan = sim.NewFan(opts)
+	go fan.Serve(ctx, l)
+
+	c = dyslink.NewClient(&dyslink.ClientOpts{
+		Username:      fan.Serial(),
+		PasswordHash:  fan.PasswordHash(),
+		Model:         fan.Model(),
+		DeviceAddress: "tcp://" + l.Addr().String(),
+	})
+	cctx, ccancel := context.WithTimeout(ctx, 5*time.Second)
+	defer ccancel()
+	if err := c.ConnectContext(cctx); err != nil {
+		cancel()
+		t.Fatalf("ConnectContext() failed: %v", err)
+	}
+	return fan, c, func() {
+		c.Disconnect(0)
+		cancel()
+	}
+}
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name            string
+		popts           dyslink.ProvisionOpts
+		provisioned     bool
+		wantProvisioned bool
+		wantErr         error
+		wantStep        string
+	}{
+		{
+			name:  "dry run",
+			popts: dyslink.ProvisionOpts{DryRun: true},
+		},
+		{
+			name:            "join network",
+			popts:           dyslink.ProvisionOpts{Ssid: "home", Password: "wifi-secret"},
+			wantProvisioned: true,
+		},
+		{
+			name:     "wrong wifi password",
+			popts:    dyslink.ProvisionOpts{Ssid: "home", Password: "wrong"},
+			wantErr:  dyslink.ErrWifiRejected,
+			wantStep: dyslink.MessageJoinNetwork,
+		},
+		{
+			name:        "already provisioned",
+			popts:       dyslink.ProvisionOpts{Ssid: "home", Password: "wifi-secret"},
+			provisioned: true,
+			wantErr:     dyslink.ErrAlreadyProvisioned,
+			wantStep:    dyslink.MessageAuthoriseUserRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fan, c, stop := startFan(t, &sim.Opts{Unprovisioned: !tt.provisioned, WifiPassword: "wifi-secret", EnvInterval: -1})
+			defer stop()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			res, err := c.Provision(ctx, &tt.popts)
+			if tt.wantErr != nil {
+				var perr *dyslink.ProvisionError
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &perr) {
+					t.Fatalf("Provision() = %v, %v, want %v", res, err, tt.wantErr)
+				}
+				if perr.Step != tt.wantStep {
+					t.Errorf("Step = %s, want %s", perr.Step, tt.wantStep)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Provision() failed: %v", err)
+			}
+			if res.Serial != fan.Serial() || res.PasswordHash != fan.PasswordHash() {
+				t.Errorf("Provision() = %+v, want %s / %s", res, fan.Serial(), fan.PasswordHash())
+			}
+			// CLOSE-ACCESS-POINT is sent without waiting for a reply
+			deadline := time.Now().Add(time.Second)
+			for fan.Provisioned() != tt.wantProvisioned && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if fan.Provisioned() != tt.wantProvisioned {
+				t.Errorf("Provisioned() = %v, want %v", fan.Provisioned(), tt.wantProvisioned)
+			}
+			// the client keeps talking to the device under its own username
+			if _, err := c.GetState(ctx); err != nil {
+				t.Errorf("GetState() after Provision() failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+)
+
+// GetState requests and returns the current state of the device
+func (c *client) GetState(ctx context.Context) (*ProductState, error) {
+	msg, err := c.requestCurrent(ctx, MessageCurrentState)
+	if err != nil {
+		return nil, err
+	}
+	ps, ok := msg.(*ProductState)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s payload %T", MessageCurrentState, msg)
+	}
+	return ps, nil
+}
+
+// GetEnvironment requests and returns the current sensor data of the device
+func (c *client) GetEnvironment(ctx context.Context) (*EnvironmentState, error) {
+	msg, err := c.requestCurrent(ctx, MessageEnvSensorData)
+	if err != nil {
+		return nil, err
+	}
+	env, ok := msg.(*EnvironmentState)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s payload %T", MessageEnvSensorData, msg)
+	}
+	return env, nil
+}
+
+// GetCurrent returns both, the current state and the sensor data of the
+// device, which are sent in reply to a single REQUEST-CURRENT-STATE
+func (c *client) GetCurrent(ctx context.Context) (*ProductState, *EnvironmentState, error) {
+	ctx, cancel := replyContext(ctx)
+	defer cancel()
+
+	sw := c.addWaiter(MessageCurrentState)
+	defer c.removeWaiter(sw)
+	ew := c.addWaiter(MessageEnvSensorData)
+	defer c.removeWaiter(ew)
+
+	if err := c.RequestCurrentStateContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	var ps *ProductState
+	var env *EnvironmentState
+	for ps == nil || env == nil {
+		select {
+		case msg := <-sw.ch:
+			var ok bool
+			if ps, ok = msg.(*ProductState); !ok {
+				return nil, nil, fmt.Errorf("unexpected %s payload %T", MessageCurrentState, msg)
+			}
+		case msg := <-ew.ch:
+			var ok bool
+			if env, ok = msg.(*EnvironmentState); !ok {
+				return nil, nil, fmt.Errorf("unexpected %s payload %T", MessageEnvSensorData, msg)
+			}
+		case <-ctx.Done():
+			command := MessageCurrentState
+			if ps != nil {
+				command = MessageEnvSensorData
+			}
+			return nil, nil, &TimeoutError{Command: command, Err: ctx.Err()}
+		}
+	}
+	return ps, env, nil
+}
+
+// requestCurrent sends a REQUEST-CURRENT-STATE command and waits
+// for the reply with given command. Other listeners will still
+// receive the reply via the callback channel.
+func (c *client) requestCurrent(ctx context.Context, command string) (interface{}, error) {
+	ctx, cancel := replyContext(ctx)
+	defer cancel()
+
+	w := c.addWaiter(command)
+	defer c.removeWaiter(w)
+
+	if err := c.RequestCurrentStateContext(ctx); err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-w.ch:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, &TimeoutError{Command: command, Err: ctx.Err()}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testCurrentState = `{"msg":"CURRENT-STATE","product-state":{"fmod":"AUTO","fnsp":"AUTO","oson":"ON"}}`
+	testEnvironment  = `{"msg":"ENVIRONMENTAL-CURRENT-SENSOR-DATA","data":{"tact":"2981","hact":"0045","pact":"0003","vact":"0002","sltm":"OFF"}}`
+)
+
+// replyCurrent answers REQUEST-CURRENT-STATE like a device: with
+// the current state, followed by the sensor data
+func replyCurrent(replies ...string) func(tr *MemoryTransport, cmd map[string]interface{}) {
+	return func(tr *MemoryTransport, cmd map[string]interface{}) {
+		if cmd["msg"] == MessageRequestCurrentState {
+			for _, r := range replies {
+				tr.Deliver(testStatusTopic, []byte(r))
+			}
+		}
+	}
+}
+
+func TestClientGetState(t *testing.T) {
+	c, tr := connectMemory(t, replyCurrent(testCurrentState, testEnvironment))
+	defer c.Disconnect(0)
+
+	ps, err := c.GetState(context.Background())
+	if err != nil {
+		t.Fatalf("GetState() failed: %v", err)
+	}
+	if ps.FanMode != FanModeAuto || ps.FanSpeed != FanSpeedAuto || ps.Oscillate != OscillateOn {
+		t.Errorf("GetState() = %+v", ps)
+	}
+	if cmds := publishedCommands(tr); len(cmds) != 1 || cmds[0] != MessageRequestCurrentState {
+		t.Errorf("GetState() published %v", cmds)
+	}
+
+	tr.Drop(errors.New("broken pipe"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetState(ctx); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("GetState() while disconnected = %v, want ErrNotConnected", err)
+	}
+}
+
+func TestClientGetEnvironment(t *testing.T) {
+	c, tr := connectMemory(t, replyCurrent(testCurrentState, testEnvironment))
+	defer c.Disconnect(0)
+
+	env, err := c.GetEnvironment(context.Background())
+	if err != nil {
+		t.Fatalf("GetEnvironment() failed: %v", err)
+	}
+	if env.Temperature != "2981" || env.Humidity != "0045" || env.SleepTimer != "OFF" {
+		t.Errorf("GetEnvironment() = %+v", env)
+	}
+	if cmds := publishedCommands(tr); len(cmds) != 1 || cmds[0] != MessageRequestCurrentState {
+		t.Errorf("GetEnvironment() published %v", cmds)
+	}
+}
+
+func TestClientGetCurrent(t *testing.T) {
+	tests := []struct {
+		name        string
+		replies     []string
+		wantTimeout string // the command of the expected TimeoutError
+	}{
+		{
+			name:    "state first",
+			replies: []string{testCurrentState, testEnvironment},
+		},
+		{
+			name:    "environment first",
+			replies: []string{testEnvironment, testCurrentState},
+		},
+		{
+			name:        "no environment",
+			replies:     []string{testCurrentState},
+			wantTimeout: MessageEnvSensorData,
+		},
+		{
+			name:        "no reply",
+			wantTimeout: MessageCurrentState,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, tr := connectMemory(t, replyCurrent(tt.replies...))
+			defer c.Disconnect(0)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			ps, env, err := c.GetCurrent(ctx)
+			if tt.wantTimeout != "" {
+				var terr *TimeoutError
+				if !errors.As(err, &terr) || terr.Command != tt.wantTimeout {
+					t.Fatalf("GetCurrent() error = %v, want a TimeoutError for %s", err, tt.wantTimeout)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetCurrent() failed: %v", err)
+			}
+			if ps.FanMode != FanModeAuto || env.Temperature != "2981" {
+				t.Errorf("GetCurrent() = %+v, %+v", ps, env)
+			}
+			if cmds := publishedCommands(tr); len(cmds) != 1 || cmds[0] != MessageRequestCurrentState {
+				t.Errorf("GetCurrent() published %v, want a single %s", cmds, MessageRequestCurrentState)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Directions of a CapturedMessage
+const (
+	DirectionIn  = "in"  // sent by the device
+	DirectionOut = "out" // sent by the client
+)
+
+// CapturedMessage is a single line of a capture file
+type CapturedMessage struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"` // One of the Direction* constants
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"` // The raw payload
+}
+
+// Recorder writes all messages of a client into a capture file
+// (one json encoded CapturedMessage per line), see ClientOpts.Recorder.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewRecorder returns a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, enc: json.NewEncoder(w)}
+}
+
+// CreateRecorder returns a recorder writing to given file, which is
+// created or appended to. The file is only readable by the current user.
+func CreateRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f), nil
+}
+
+// Record appends a message to the capture
+func (r *Recorder) Record(direction string, topic string, payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(&CapturedMessage{Time: time.Now().UTC(), Direction: direction, Topic: topic, Payload: string(payload)})
+}
+
+// Close closes the underlying writer, if it is an io.Closer
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// record passes a message to the recorder of the client, if any
+func (c *client) record(direction string, topic string, payload []byte) {
+	if c.opts.Recorder == nil {
+		return
+	}
+	if err := c.opts.Recorder.Record(direction, topic, payload); err != nil {
+		c.log(LevelWarn, fmt.Sprintf("recording failed: %v", err), Fields{FieldTopic: topic})
+	}
+}
+
+// ReadCapture reads the messages of a capture file
+func ReadCapture(r io.Reader) ([]*CapturedMessage, error) {
+	var msgs []*CapturedMessage
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		m := &CapturedMessage{}
+		if err := json.Unmarshal(sc.Bytes(), m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, sc.Err()
+}
+
+// LoadCapture reads the messages of given capture file
+func LoadCapture(path string) ([]*CapturedMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	msgs, err := ReadCapture(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return msgs, nil
+}
+
+// ReplayTransport is a Transport feeding the incoming messages of a capture
+// to the client, in order, once it connected. Messages sent by the client
+// are not delivered anywhere but can be inspected via Published.
+type ReplayTransport struct {
+	Realtime bool // Keep the original delay between messages instead of replaying them at once
+
+	msgs []*CapturedMessage
+	done chan struct{}
+
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	published []*MemoryMessage
+}
+
+// NewReplayTransport returns a transport replaying given messages
+func NewReplayTransport(msgs []*CapturedMessage) *ReplayTransport {
+	return &ReplayTransport{msgs: msgs, done: make(chan struct{})}
+}
+
+// Done is closed once all messages were delivered (or the client disconnected)
+func (t *ReplayTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Connect starts the replay. A capture is only replayed once:
+// connecting again returns an error.
+func (t *ReplayTransport) Connect(ctx context.Context, h *TransportHandlers) error {
+	rctx, cancel := context.WithCancel(context.Background())
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.mu.Unlock()
+		cancel()
+		return fmt.Errorf("replay transport already connected")
+	}
+	t.cancel = cancel
+	t.mu.Unlock()
+
+	go func() {
+		defer close(t.done)
+		h.Connected()
+
+		var last time.Time
+		for _, m := range t.msgs {
+			if m.Direction != DirectionIn {
+				continue
+			}
+			if t.Realtime && !last.IsZero() && m.Time.After(last) {
+				select {
+				case <-time.After(m.Time.Sub(last)):
+				case <-rctx.Done():
+					return
+				}
+			}
+			last = m.Time
+			if rctx.Err() != nil {
+				return
+			}
+			h.Message(m.Topic, []byte(m.Payload))
+		}
+	}()
+	return nil
+}
+
+func (t *ReplayTransport) Disconnect(quiesce uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+func (t *ReplayTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.published = append(t.published, &MemoryMessage{Topic: topic, Payload: payload})
+	return nil
+}
+
+func (t *ReplayTransport) Subscribe(ctx context.Context, topic string) error {
+	return nil
+}
+
+// Published returns all messages published by the client so far
+func (t *ReplayTransport) Published() []*MemoryMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*MemoryMessage(nil), t.published...)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplayCapture(t *testing.T) {
+	msgs, err := LoadCapture("testdata/capture-475.jsonl")
+	if err != nil {
+		t.Fatalf("LoadCapture() failed: %v", err)
+	}
+	if len(msgs) != 7 {
+		t.Fatalf("LoadCapture() returned %d messages, want 7", len(msgs))
+	}
+
+	tr := NewReplayTransport(msgs)
+	events := make(chan *Event, 16)
+	c := NewClient(&ClientOpts{Username: testSerial, Model: TypeModelN475, Transport: tr, EventChan: events})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+	select {
+	case <-tr.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("replay did not finish")
+	}
+	if err := tr.Connect(context.Background(), &TransportHandlers{}); err == nil {
+		t.Errorf("second Connect() succeeded, want an error")
+	}
+
+	var got []*Event
+	for len(events) > 0 {
+		ev := <-events
+		if ev.Kind != EventConnection {
+			got = append(got, ev)
+		}
+	}
+	kinds := []EventKind{EventCurrentState, EventEnvironment, EventStateChange, EventFaults, EventRaw}
+	if len(got) != len(kinds) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(kinds), got)
+	}
+	for i, k := range kinds {
+		if got[i].Kind != k || got[i].Error != nil {
+			t.Fatalf("event %d = %s (error %v), want %s", i, got[i].Kind, got[i].Error, k)
+		}
+		if got[i].Device != testSerial {
+			t.Errorf("event %d has device %q, want %q", i, got[i].Device, testSerial)
+		}
+	}
+
+	if ps, _ := got[0].ProductState(); ps.FanMode != FanModeOn || ps.FanSpeed != "0004" || ps.Oscillate != OscillateOn {
+		t.Errorf("current state = %+v", ps)
+	}
+	if env, _ := got[1].Environment(); env.Temperature != "2950" || env.Humidity != "0045" || env.Particle != "0002" {
+		t.Errorf("environment = %+v", env)
+	}
+	sc, _ := got[2].StateChange()
+	if fc, ok := sc.Changed("fnsp"); !ok || fc.Old != "0004" || fc.New != "0007" || len(sc.Changes) != 1 {
+		t.Errorf("state change = %s, want fnsp 0004->0007 only", sc)
+	}
+	if df, _ := got[3].Faults(); len(df.Faults) != 1 || df.Faults[0].Code != "fltr" || df.HasErrors() {
+		t.Errorf("faults = %+v, want the filter warning only", df)
+	}
+	if raw, _ := got[4].Raw(); raw.Command != "STATE-SETTINGS" {
+		t.Errorf("raw message = %+v", raw)
+	}
+
+	// messages sent by the client are not replayed
+	if pub := tr.Published(); len(pub) != 0 {
+		t.Errorf("client published %d messages during the replay", len(pub))
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultResolveTimeout is the time ResolveOpts waits for the device to show up via mDNS
+const DefaultResolveTimeout = 5 * time.Second
+
+// ResolveOptions are the (command line) settings passed to ResolveOpts, all are optional
+type ResolveOptions struct {
+	CredentialsPath string // Read the credentials from this file, see ResolveCredentials
+	Username        string // Overrides the username of the credentials
+	Password        string // Overrides the password of the credentials
+	Ssid            string // A setup ssid or serial, see OptsFromSetup. Overrides the username
+	Model           string // Takes precedence over the model of the credentials, the ssid and discovery
+	Host            string // The ip:port of the device
+	Discover        bool   // Find the device by its serial via mDNS if Host is empty
+}
+
+// ResolveOpts assembles the ClientOpts of a command line tool.
+// If Host is empty and Discover is set, the device is discovered by its serial
+// via mDNS, which blocks for up to DefaultResolveTimeout. This happens once:
+// reconnects of the client use the address found at startup.
+// The model of a plain serial with an unknown prefix is taken from discovery.
+// The model defaults to TypeModelN475 if it is still unknown.
+func ResolveOpts(ropts *ResolveOptions) (*ClientOpts, error) {
+	opts := &ClientOpts{Model: ropts.Model}
+
+	creds, err := ResolveCredentials(ropts.CredentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading credentials: %w", err)
+	}
+	if creds != nil {
+		creds.Apply(opts)
+	}
+	if ropts.Username != "" {
+		opts.Username = ropts.Username
+	}
+	if ropts.Password != "" {
+		opts.Password = ropts.Password
+		opts.PasswordHash = ""
+	}
+	if ropts.Ssid != "" {
+		serial, model, err := parseSetupSsid(ropts.Ssid)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case ropts.Model != "":
+			// opts.Model is already set
+		case model != "":
+			if _, ok := LookupModel(model); !ok {
+				return nil, fmt.Errorf("unknown product type %s of %s", model, serial)
+			}
+			opts.Model = model
+		case ropts.Host == "" && ropts.Discover:
+			// the model of this serial is reported by discovery
+			opts.Model = ""
+		default:
+			return nil, fmt.Errorf("%w %s of %s, pass the full setup ssid or the model", ErrUnknownSerialPrefix, serial[:3], serial)
+		}
+		opts.Username = serial
+		if opts.Password != "" {
+			opts.PasswordHash = encodePassword(opts.Password)
+			opts.Password = ""
+		}
+	}
+
+	switch {
+	case ropts.Host != "":
+		opts.DeviceAddress = fmt.Sprintf("tcp://%s", ropts.Host)
+	case !ropts.Discover:
+		return nil, fmt.Errorf("no host given and discovery is disabled")
+	default:
+		if opts.Username == "" {
+			return nil, fmt.Errorf("neither a host nor a serial given")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultResolveTimeout)
+		defer cancel()
+		d, err := FindDevice(ctx, opts.Username)
+		if err != nil {
+			return nil, fmt.Errorf("discovering %s: %w", opts.Username, err)
+		}
+		opts.DeviceAddress = d.DeviceAddress()
+		if opts.Model == "" {
+			opts.Model = d.Model
+		}
+	}
+	if opts.Model == "" {
+		opts.Model = TypeModelN475
+	}
+	return opts, nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveOpts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dyslink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credPath := filepath.Join(dir, "creds.json")
+	if err := SaveCredentials(credPath, &Credentials{Serial: "NN4-CH-HEA0322B", PasswordHash: "hash", Model: TypeModelN438}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                                 string
+		credPath, user, pass, ssid, model    string
+		host                                 string // defaults to 127.0.0.1:1883
+		wantUser, wantPass, wantHash, wantMd string
+		err                                  bool
+	}{
+		{
+			name:     "credentials file",
+			credPath: credPath,
+			wantUser: "NN4-CH-HEA0322B", wantHash: "hash", wantMd: TypeModelN438,
+		},
+		{
+			name:     "flags override the credentials file",
+			credPath: credPath, user: "NN4-CH-XXX0000X", pass: "secret", model: TypeModelN520,
+			wantUser: "NN4-CH-XXX0000X", wantPass: "secret", wantMd: TypeModelN520,
+		},
+		{
+			name:     "setup ssid",
+			credPath: credPath, ssid: "DYSON-JH1-US-HBB1111A-455",
+			wantUser: "JH1-US-HBB1111A", wantHash: "hash", wantMd: TypeModelN455,
+		},
+		{
+			name:     "password flag replaces the hash",
+			credPath: credPath, pass: "secret",
+			wantUser: "NN4-CH-HEA0322B", wantPass: "secret", wantMd: TypeModelN438,
+		},
+		{
+			name:     "setup ssid hashes the password",
+			credPath: credPath, pass: "secret", ssid: "DYSON-NN4-CH-HEA0322B-475",
+			wantUser: "NN4-CH-HEA0322B", wantHash: encodePassword("secret"), wantMd: TypeModelN475,
+		},
+		{
+			name:     "serial with unknown prefix and model",
+			credPath: credPath, ssid: "VS9-EU-KAA0000A", model: TypeModelN438E,
+			wantUser: "VS9-EU-KAA0000A", wantHash: "hash", wantMd: TypeModelN438E,
+		},
+		{
+			name:     "serial with unknown prefix",
+			credPath: credPath, ssid: "VS9-EU-KAA0000A",
+			err: true,
+		},
+		{
+			name:     "flag model is not checked",
+			credPath: credPath, ssid: "DYSON-NN4-CH-HEA0322B-475", model: "999",
+			wantUser: "NN4-CH-HEA0322B", wantHash: "hash", wantMd: "999",
+		},
+		{
+			name:     "no host and discovery disabled",
+			credPath: credPath, host: "-",
+			err: true,
+		},
+		{
+			name:     "invalid ssid",
+			credPath: credPath, ssid: "not a fan",
+			err: true,
+		},
+		{
+			name:     "missing credentials file",
+			credPath: filepath.Join(dir, "missing.json"),
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host
+			switch host {
+			case "":
+				host = "127.0.0.1:1883"
+			case "-":
+				host = ""
+			}
+			opts, err := ResolveOpts(&ResolveOptions{
+				CredentialsPath: tt.credPath,
+				Username:        tt.user,
+				Password:        tt.pass,
+				Ssid:            tt.ssid,
+				Model:           tt.model,
+				Host:            host,
+			})
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ResolveOpts() = %+v, want an error", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveOpts() failed: %v", err)
+			}
+			if opts.Username != tt.wantUser || opts.Password != tt.wantPass || opts.PasswordHash != tt.wantHash || opts.Model != tt.wantMd {
+				t.Errorf("ResolveOpts() = %+v", opts)
+			}
+			if opts.DeviceAddress != "tcp://127.0.0.1:1883" {
+				t.Errorf("DeviceAddress = %q", opts.DeviceAddress)
+			}
+		})
+	}
+}
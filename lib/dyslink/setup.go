@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// setupSsidRe matches the ssid of an unconfigured device, eg. DYSON-NN4-CH-HEA0322B-475
+var setupSsidRe = regexp.MustCompile(`^(?:DYSON-)?([0-9A-Z]{3}-[A-Z]{2}-[0-9A-Z]{8})(?:-([0-9]{3}[A-Z]?))?$`)
+
+// ErrUnknownSerialPrefix is returned by OptsFromSetup if the product type
+// can not be derived from a serial. Pass the full setup ssid instead.
+var ErrUnknownSerialPrefix = errors.New("unknown serial prefix")
+
+// OptsFromSetup returns the ClientOpts of a device, given the ssid of its setup
+// network (or just its serial) and the password printed on its sticker.
+// DeviceAddress is left empty.
+func OptsFromSetup(ssid string, password string) (*ClientOpts, error) {
+	serial, model, err := parseSetupSsid(ssid)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		return nil, fmt.Errorf("%w %s of %s, pass the full setup ssid or the model", ErrUnknownSerialPrefix, serial[:3], serial)
+	}
+	if _, ok := LookupModel(model); !ok {
+		return nil, fmt.Errorf("unknown product type %s of %s", model, serial)
+	}
+
+	opts := &ClientOpts{Username: serial, Model: model}
+	if password != "" {
+		opts.PasswordHash = encodePassword(password)
+	}
+	return opts, nil
+}
+
+// parseSetupSsid returns the serial and model of a setup ssid or serial.
+// The model is taken from the ssid or derived from the SerialPrefixes
+// of the known models, it is empty if neither is possible.
+func parseSetupSsid(ssid string) (serial string, model string, err error) {
+	m := setupSsidRe.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(ssid)))
+	if m == nil {
+		return "", "", fmt.Errorf("%q is not a valid setup ssid or serial", ssid)
+	}
+	serial, model = m[1], m[2]
+	if model == "" {
+		model = modelOfSerial(serial)
+	}
+	return serial, model, nil
+}
+
+// modelOfSerial returns the known model whose SerialPrefixes
+// contain the first part of serial, or an empty string
+func modelOfSerial(serial string) string {
+	prefix := serial[:3]
+	for _, model := range KnownModels() {
+		for _, p := range modelCapabilities[model].SerialPrefixes {
+			if p == prefix {
+				return model
+			}
+		}
+	}
+	return ""
+}
+
+// OptsFromQRCode is OptsFromSetup for the content of the qr code on the sticker,
+// which uses the common `WIFI:S:<ssid>;T:WPA;P:<password>;;` format.
+func OptsFromQRCode(payload string) (*ClientOpts, error) {
+	if !strings.HasPrefix(payload, "WIFI:") {
+		return nil, fmt.Errorf("not a wifi qr code")
+	}
+	var ssid, password string
+	for _, field := range splitQRFields(strings.TrimPrefix(payload, "WIFI:")) {
+		switch {
+		case strings.HasPrefix(field, "S:"):
+			ssid = field[2:]
+		case strings.HasPrefix(field, "P:"):
+			password = field[2:]
+		}
+	}
+	if ssid == "" {
+		return nil, fmt.Errorf("qr code contains no ssid")
+	}
+	return OptsFromSetup(ssid, password)
+}
+
+// splitQRFields splits the fields of a wifi qr code at unescaped semicolons
+func splitQRFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOptsFromSetup(t *testing.T) {
+	tests := []struct {
+		name      string
+		ssid      string
+		password  string
+		wantUser  string
+		wantModel string
+		err       error // only checked via errors.Is if set
+		fail      bool
+	}{
+		{"full ssid", "DYSON-NN4-CH-HEA0322B-475", "secret", "NN4-CH-HEA0322B", TypeModelN475, nil, false},
+		{"ssid without prefix", "JH1-US-HBB1111A-438E", "", "JH1-US-HBB1111A", TypeModelN438E, nil, false},
+		{"lower case and spaces", " dyson-nn4-ch-hea0322b-455 ", "", "NN4-CH-HEA0322B", TypeModelN455, nil, false},
+		{"serial with known prefix", "NN4-CH-HEA0322B", "", "NN4-CH-HEA0322B", TypeModelN475, nil, false},
+		{"serial with unknown prefix", "VS9-EU-KAA0000A", "", "", "", ErrUnknownSerialPrefix, true},
+		{"unknown model", "DYSON-NN4-CH-HEA0322B-999", "", "", "", nil, true},
+		{"garbage", "MyHomeWifi", "", "", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := OptsFromSetup(tt.ssid, tt.password)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("OptsFromSetup() = %+v, want an error", opts)
+				}
+				if tt.err != nil && !errors.Is(err, tt.err) {
+					t.Errorf("OptsFromSetup() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OptsFromSetup() failed: %v", err)
+			}
+			if opts.Username != tt.wantUser || opts.Model != tt.wantModel {
+				t.Errorf("OptsFromSetup() = %+v, want user %s and model %s", opts, tt.wantUser, tt.wantModel)
+			}
+			wantHash := ""
+			if tt.password != "" {
+				wantHash = encodePassword(tt.password)
+			}
+			if opts.PasswordHash != wantHash || opts.Password != "" {
+				t.Errorf("OptsFromSetup() password = %q, hash = %q, want hash %q", opts.Password, opts.PasswordHash, wantHash)
+			}
+		})
+	}
+}
+
+func TestSerialPrefixesAreUnique(t *testing.T) {
+	seen := make(map[string]string)
+	for _, model := range KnownModels() {
+		caps, _ := LookupModel(model)
+		for _, p := range caps.SerialPrefixes {
+			if other, ok := seen[p]; ok {
+				t.Errorf("serial prefix %s is used by %s and %s", p, other, model)
+			}
+			seen[p] = model
+			if got := modelOfSerial(p + "-CH-HEA0322B"); got != model {
+				t.Errorf("modelOfSerial(%s-...) = %q, want %q", p, got, model)
+			}
+		}
+	}
+}
+
+func TestOptsFromQRCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		wantUser string
+		wantHash string
+		fail     bool
+	}{
+		{"sticker", "WIFI:S:DYSON-NN4-CH-HEA0322B-475;T:WPA;P:abcdefgh;;", "NN4-CH-HEA0322B", encodePassword("abcdefgh"), false},
+		{"field order", "WIFI:T:WPA;P:abcdefgh;S:DYSON-NN4-CH-HEA0322B-475;;", "NN4-CH-HEA0322B", encodePassword("abcdefgh"), false},
+		{"escaped password", `WIFI:S:DYSON-NN4-CH-HEA0322B-475;T:WPA;P:ab\;c\\d;;`, "NN4-CH-HEA0322B", encodePassword(`ab;c\d`), false},
+		{"no password", "WIFI:S:DYSON-NN4-CH-HEA0322B-475;T:nopass;;", "NN4-CH-HEA0322B", "", false},
+		{"no ssid", "WIFI:T:WPA;P:abcdefgh;;", "", "", true},
+		{"not a wifi code", "https://www.dyson.com", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := OptsFromQRCode(tt.payload)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("OptsFromQRCode() = %+v, want an error", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OptsFromQRCode() failed: %v", err)
+			}
+			if opts.Username != tt.wantUser || opts.PasswordHash != tt.wantHash {
+				t.Errorf("OptsFromQRCode() = %+v, want user %s and hash %s", opts, tt.wantUser, tt.wantHash)
+			}
+		})
+	}
+}
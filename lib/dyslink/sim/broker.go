@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package sim
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// broker is a minimal mqtt 3.1.1 broker, just good enough to talk to
+// dyslink (or the app): QoS 0 delivery, no retained messages, no will.
+type broker struct {
+	auth      func(username string, password string) bool // decides if a client may connect
+	onPublish func(topic string, payload []byte)          // called for each message published by a client
+
+	mu       sync.Mutex
+	sessions map[*session]bool
+}
+
+// session is a connected client
+type session struct {
+	conn net.Conn
+	wmu  sync.Mutex
+	mu   sync.Mutex
+	subs map[string]bool
+}
+
+func newBroker() *broker {
+	return &broker{sessions: make(map[*session]bool)}
+}
+
+// serve accepts connections until the context is done
+func (b *broker) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+		b.mu.Lock()
+		for s := range b.sessions {
+			s.conn.Close()
+		}
+		b.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go b.handle(conn, b.auth)
+	}
+}
+
+// handle serves a single client connection, checking its login via auth (if not nil)
+func (b *broker) handle(conn net.Conn, auth func(username string, password string) bool) {
+	defer conn.Close()
+
+	cp, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := cp.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = connect.Validate()
+	if connack.ReturnCode == packets.Accepted && auth != nil && !auth(connect.Username, string(connect.Password)) {
+		connack.ReturnCode = packets.ErrRefusedNotAuthorised
+	}
+	if err := connack.Write(conn); err != nil || connack.ReturnCode != packets.Accepted {
+		return
+	}
+
+	s := &session{conn: conn, subs: make(map[string]bool)}
+	b.mu.Lock()
+	b.sessions[s] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, s)
+		b.mu.Unlock()
+	}()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := cp.(type) {
+		case *packets.PublishPacket:
+			switch p.Qos {
+			case 1:
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				s.write(ack)
+			case 2:
+				rec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				rec.MessageID = p.MessageID
+				s.write(rec)
+			}
+			if b.onPublish != nil {
+				b.onPublish(p.TopicName, p.Payload)
+			}
+		case *packets.PubrelPacket:
+			comp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			comp.MessageID = p.MessageID
+			s.write(comp)
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			s.mu.Lock()
+			for _, t := range p.Topics {
+				s.subs[t] = true
+				ack.ReturnCodes = append(ack.ReturnCodes, 0) // we only deliver with QoS 0
+			}
+			s.mu.Unlock()
+			s.write(ack)
+		case *packets.UnsubscribePacket:
+			s.mu.Lock()
+			for _, t := range p.Topics {
+				delete(s.subs, t)
+			}
+			s.mu.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			s.write(ack)
+		case *packets.PingreqPacket:
+			s.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// publish delivers a message to all clients subscribed to topic
+func (b *broker) publish(topic string, payload []byte) {
+	b.mu.Lock()
+	sessions := make([]*session, 0, len(b.sessions))
+	for s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range sessions {
+		if s.subscribed(topic) {
+			p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			p.TopicName = topic
+			p.Payload = payload
+			s.write(p)
+		}
+	}
+}
+
+// write sends a packet to the client
+func (s *session) write(cp packets.ControlPacket) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return cp.Write(s.conn)
+}
+
+// subscribed returns true if any subscription of the session matches topic
+func (s *session) subscribed(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for filter := range s.subs {
+		if topicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// topicMatches returns true if topic matches the filter, which may contain + and # wildcards
+func topicMatches(filter string, topic string) bool {
+	fparts := strings.Split(filter, "/")
+	tparts := strings.Split(topic, "/")
+	for i, f := range fparts {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tparts) || (f != "+" && f != tparts[i]) {
+			return false
+		}
+	}
+	return len(fparts) == len(tparts)
+}
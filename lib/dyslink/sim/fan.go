@@ -0,0 +1,411 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+// Package sim simulates a fan: It runs an embedded mqtt broker which
+// behaves like the broker of a real device, for tests and demos.
+package sim
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+)
+
+// Defaults used if not set in Opts
+const (
+	DefaultSerial      = "SIM-XX-AAA0000A"
+	DefaultPassword    = "simulator"
+	DefaultEnvInterval = 30 * time.Second
+)
+
+// Username used by the client while bootstrapping
+const provisionUsername = "initialconnection"
+
+// Reasons sent in a PROVISIONING-FAILED message
+const (
+	reasonWifi        = "WIFI-AUTH-FAILED"
+	reasonProvisioned = "ALREADY-PROVISIONED"
+)
+
+// Opts configures a simulated fan
+type Opts struct {
+	Model         string            // One of the dyslink.TypeModel* constants, defaults to dyslink.TypeModelN475
+	Serial        string            // The serial (and username) of the fan, defaults to DefaultSerial
+	Password      string            // The password printed on the sticker, defaults to DefaultPassword
+	State         map[string]string // Overrides fields of the initial product state
+	Sensors       map[string]Curve  // The reported sensor data, defaults to DefaultSensors
+	EnvInterval   time.Duration     // Interval of sensor data messages, defaults to DefaultEnvInterval. Negative disables them
+	Unprovisioned bool              // Start like a factory reset fan: accept any login and hand out the credentials
+	WifiPassword  string            // If set, JOIN-NETWORK fails unless this password is used
+	Logger        dyslink.Logger    // Receives all messages sent and received, discarded if nil
+}
+
+// Fan is a simulated fan
+type Fan struct {
+	opts    Opts
+	broker  *broker
+	started time.Time
+
+	mu          sync.Mutex
+	state       map[string]string
+	provisioned bool
+	wifiFailed  bool
+}
+
+// NewFan returns a new simulated fan, use Serve or ListenAndServe to start it
+func NewFan(opts *Opts) *Fan {
+	f := &Fan{opts: *opts, broker: newBroker(), started: time.Now()}
+	if f.opts.Model == "" {
+		f.opts.Model = dyslink.TypeModelN475
+	}
+	if f.opts.Serial == "" {
+		f.opts.Serial = DefaultSerial
+	}
+	if f.opts.Password == "" {
+		f.opts.Password = DefaultPassword
+	}
+	if f.opts.Sensors == nil {
+		f.opts.Sensors = DefaultSensors(f.opts.Model)
+	}
+	if f.opts.EnvInterval == 0 {
+		f.opts.EnvInterval = DefaultEnvInterval
+	}
+	f.state = defaultState(f.opts.Model)
+	for k, v := range f.opts.State {
+		f.state[k] = v
+	}
+	f.provisioned = !f.opts.Unprovisioned
+	f.broker.auth = f.auth
+	f.broker.onPublish = f.handleMessage
+	return f
+}
+
+// Model returns the model of the fan
+func (f *Fan) Model() string {
+	return f.opts.Model
+}
+
+// Serial returns the serial of the fan
+func (f *Fan) Serial() string {
+	return f.opts.Serial
+}
+
+// PasswordHash returns the hashed password, as used by the mqtt login
+func (f *Fan) PasswordHash() string {
+	sum := sha512.Sum512([]byte(f.opts.Password))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Credentials returns the credentials to connect to the fan
+func (f *Fan) Credentials() *dyslink.Credentials {
+	return &dyslink.Credentials{Serial: f.opts.Serial, PasswordHash: f.PasswordHash(), Model: f.opts.Model}
+}
+
+// Provisioned returns false until a client bootstrapped the fan
+func (f *Fan) Provisioned() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.provisioned
+}
+
+// State returns a copy of the current product state
+func (f *Fan) State() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state := make(map[string]string, len(f.state))
+	for k, v := range f.state {
+		state[k] = v
+	}
+	return state
+}
+
+// ListenAndServe listens on given address (eg. 127.0.0.1:1883) and serves until the context is done
+func (f *Fan) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return f.Serve(ctx, l)
+}
+
+// Serve serves clients on given listener until the context is done
+func (f *Fan) Serve(ctx context.Context, l net.Listener) error {
+	if f.opts.EnvInterval > 0 {
+		go func() {
+			t := time.NewTicker(f.opts.EnvInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					f.PublishEnvironment()
+				}
+			}
+		}()
+	}
+	return f.broker.serve(ctx, l)
+}
+
+// ServeConn serves a single, already authenticated client connection until
+// it is closed, eg. a websocket accepted by a stub of the cloud endpoint.
+// The mqtt login of the client is not checked.
+func (f *Fan) ServeConn(conn net.Conn) {
+	f.broker.handle(conn, nil)
+}
+
+// Apply changes the product state as if it was done via the remote (or the app),
+// reporting the change in a STATE-CHANGE message with given mode-reason.
+// Unknown fields are ignored.
+func (f *Fan) Apply(reason string, fields map[string]string) {
+	f.mu.Lock()
+	old := make(map[string]string, len(f.state))
+	for k, v := range f.state {
+		old[k] = v
+	}
+	for k, v := range fields {
+		if _, ok := f.state[k]; ok {
+			f.state[k] = v
+		}
+	}
+	f.updateDerived()
+	change := make(map[string][2]string, len(f.state))
+	for k, v := range f.state {
+		change[k] = [2]string{old[k], v}
+	}
+	f.mu.Unlock()
+
+	f.publish(f.statusTopic(f.opts.Serial), map[string]interface{}{
+		"msg":           dyslink.MessageStateChange,
+		"mode-reason":   reason,
+		"state-reason":  "MODE",
+		"product-state": change,
+	})
+}
+
+// PublishEnvironment sends the current sensor data
+func (f *Fan) PublishEnvironment() {
+	f.mu.Lock()
+	sltm := f.state["sltm"]
+	f.mu.Unlock()
+
+	f.publish(f.statusTopic(f.opts.Serial), map[string]interface{}{
+		"msg":  dyslink.MessageEnvSensorData,
+		"data": sensorData(f.opts.Sensors, time.Since(f.started), sltm),
+	})
+}
+
+// updateDerived updates the fields which are not set by clients
+func (f *Fan) updateDerived() {
+	on := f.state["fmod"] != dyslink.FanModeOff
+	if _, ok := f.state["fpwr"]; ok {
+		on = f.state["fpwr"] == dyslink.FanPowerOn
+	}
+	f.state["fnst"] = "OFF"
+	if on {
+		f.state["fnst"] = "FAN"
+	}
+	if _, ok := f.state["oscs"]; ok {
+		switch {
+		case f.state["oson"] != dyslink.OscillateOnV2:
+			f.state["oscs"] = dyslink.OscillateOff
+		case on:
+			f.state["oscs"] = dyslink.OscillateOn
+		default:
+			f.state["oscs"] = dyslink.OscillationIdle
+		}
+	}
+}
+
+// auth checks the login of a client
+func (f *Fan) auth(username string, password string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.provisioned {
+		return true
+	}
+	return username == f.opts.Serial && password == f.PasswordHash()
+}
+
+// command is a message sent by a client
+type command struct {
+	Command      string                 `json:"msg"`
+	Data         map[string]interface{} `json:"data"`
+	RequestId    string                 `json:"requestId"`
+	WifiSsid     string                 `json:"ssid"`
+	WifiPassword string                 `json:"password"`
+}
+
+// handleMessage handles a message published by a client
+func (f *Fan) handleMessage(topic string, payload []byte) {
+	f.log(dyslink.LevelDebug, fmt.Sprintf("received %s", payload), dyslink.Fields{dyslink.FieldTopic: topic, dyslink.FieldDirection: "in"})
+
+	parts := strings.Split(topic, "/")
+	if len(parts) != 3 || parts[0] != f.opts.Model || parts[2] != "command" {
+		return
+	}
+	user := parts[1]
+	if user != f.opts.Serial && user != provisionUsername {
+		return
+	}
+
+	cmd := &command{}
+	if err := json.Unmarshal(payload, cmd); err != nil {
+		f.log(dyslink.LevelWarn, fmt.Sprintf("invalid message: %v", err), dyslink.Fields{dyslink.FieldTopic: topic})
+		return
+	}
+
+	switch cmd.Command {
+	case dyslink.MessageRequestCurrentState:
+		f.publish(f.statusTopic(user), map[string]interface{}{
+			"msg":           dyslink.MessageCurrentState,
+			"mode-reason":   "LAPP",
+			"state-reason":  "MODE",
+			"product-state": f.State(),
+		})
+		f.PublishEnvironment()
+	case dyslink.MessageRequestFaults:
+		f.publish(f.statusTopic(user), map[string]interface{}{
+			"msg":              dyslink.MessageCurrentFaults,
+			"product-errors":   map[string]string{},
+			"product-warnings": map[string]string{},
+			"module-errors":    map[string]string{},
+			"module-warnings":  map[string]string{},
+		})
+	case "STATE-SET":
+		fields := make(map[string]string, len(cmd.Data))
+		for k, v := range cmd.Data {
+			if s, ok := v.(string); ok {
+				fields[k] = s
+			}
+		}
+		f.Apply("LAPP", fields)
+	case dyslink.MessageJoinNetwork:
+		f.mu.Lock()
+		f.wifiFailed = f.opts.WifiPassword != "" && cmd.WifiPassword != f.opts.WifiPassword
+		failed := f.wifiFailed
+		f.mu.Unlock()
+		if failed {
+			f.provisioningFailed(user, cmd.RequestId, reasonWifi)
+		}
+	case dyslink.MessageAuthoriseUserRequest:
+		f.mu.Lock()
+		provisioned, failed := f.provisioned, f.wifiFailed
+		f.mu.Unlock()
+		if provisioned {
+			f.provisioningFailed(user, cmd.RequestId, reasonProvisioned)
+		} else if !failed {
+			f.publish(f.credentialsTopic(user), map[string]interface{}{
+				"msg":            dyslink.MessageDeviceCredentials,
+				"serialNumber":   f.opts.Serial,
+				"apPasswordHash": f.PasswordHash(),
+			})
+		}
+	case dyslink.MessageCloseAccessPoint:
+		f.mu.Lock()
+		f.provisioned = true
+		f.mu.Unlock()
+	default:
+		f.log(dyslink.LevelWarn, fmt.Sprintf("unknown command %s", cmd.Command), dyslink.Fields{dyslink.FieldTopic: topic})
+	}
+}
+
+// provisioningFailed reports a failed bootstrap step
+func (f *Fan) provisioningFailed(user string, requestId string, reason string) {
+	f.publish(f.credentialsTopic(user), map[string]interface{}{
+		"msg":       dyslink.MessageProvisioningFailed,
+		"requestId": requestId,
+		"reason":    reason,
+	})
+}
+
+// publish adds the time to a message and sends it to given topic
+func (f *Fan) publish(topic string, msg map[string]interface{}) {
+	msg["time"] = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		f.log(dyslink.LevelError, fmt.Sprintf("encoding message: %v", err), nil)
+		return
+	}
+	f.log(dyslink.LevelDebug, fmt.Sprintf("sending %s", raw), dyslink.Fields{dyslink.FieldTopic: topic, dyslink.FieldDirection: "out"})
+	f.broker.publish(topic, raw)
+}
+
+func (f *Fan) statusTopic(user string) string {
+	return fmt.Sprintf("%s/%s/status/current", f.opts.Model, user)
+}
+
+func (f *Fan) credentialsTopic(user string) string {
+	return fmt.Sprintf("%s/%s/credentials", f.opts.Model, user)
+}
+
+func (f *Fan) log(level dyslink.LogLevel, msg string, fields dyslink.Fields) {
+	if f.opts.Logger == nil {
+		return
+	}
+	if fields == nil {
+		fields = dyslink.Fields{}
+	}
+	fields[dyslink.FieldDevice] = f.opts.Serial
+	fields[dyslink.FieldComponent] = "sim"
+	f.opts.Logger.Log(level, msg, fields)
+}
+
+// defaultState returns the initial product state of given model
+func defaultState(model string) map[string]string {
+	caps, _ := dyslink.LookupModel(model)
+	state := map[string]string{
+		"fnst": "FAN",
+		"fnsp": "0004",
+		"oson": "OFF",
+		"sltm": "OFF",
+		"rhtm": "ON",
+		"qtar": "0003",
+		"nmod": "OFF",
+		"ercd": "NONE",
+		"wacd": "NONE",
+	}
+	if caps.ProtocolV2 {
+		state["fpwr"] = dyslink.FanPowerOn
+		state["auto"] = dyslink.AutoModeOff
+		state["oson"] = dyslink.OscillateOffV2
+		state["oscs"] = dyslink.OscillateOff
+		state["fdir"] = dyslink.FrontAirflowOn
+		state["nmdv"] = "0004"
+		state["cflr"] = "0100"
+		state["hflr"] = "0095"
+		if caps.OscillationAngles != nil {
+			state["osal"] = "0045"
+			state["osau"] = "0315"
+			state["ancp"] = dyslink.AngleCustom
+		}
+	} else {
+		state["fmod"] = dyslink.FanModeOn
+		state["rstf"] = "STET"
+		state["filf"] = "2087"
+	}
+	if caps.Heating {
+		state["hmod"] = "OFF"
+		state["hsta"] = "OFF"
+		state["hmax"] = "2960"
+	}
+	if caps.FocusMode {
+		state["ffoc"] = "OFF"
+	}
+	return state
+}
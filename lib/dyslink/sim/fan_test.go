@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package sim_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+	"github.com/adrian-bl/dyslink/lib/dyslink/sim"
+)
+
+// serveFan serves fan on a random local port, returning its address.
+// stop shuts the fan down.
+func serveFan(t *testing.T, fan *sim.Fan) (addr string, stop func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go fan.Serve(ctx, l)
+	return "tcp://" + l.Addr().String(), cancel
+}
+
+// connect returns a client logged into the fan at addr
+func connect(addr string, username string, passwordHash string, model string) (dyslink.Client, error) {
+	c := dyslink.NewClient(&dyslink.ClientOpts{
+		Username:      username,
+		PasswordHash:  passwordHash,
+		Model:         model,
+		DeviceAddress: addr,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c, c.ConnectContext(ctx)
+}
+
+func TestFanCurrentState(t *testing.T) {
+	fan := sim.NewFan(&sim.Opts{
+		EnvInterval: -1,
+		State:       map[string]string{"fmod": dyslink.FanModeOn, "fnsp": "0004"},
+		Sensors:     map[string]sim.Curve{"tact": sim.Constant(2950), "hact": sim.Constant(45)},
+	})
+	addr, stop := serveFan(t, fan)
+	defer stop()
+	c, err := connect(addr, fan.Serial(), fan.PasswordHash(), fan.Model())
+	if err != nil {
+		t.Fatalf("ConnectContext() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ps, err := c.GetState(ctx)
+	if err != nil {
+		t.Fatalf("GetState() failed: %v", err)
+	}
+	if ps.FanMode != dyslink.FanModeOn || ps.FanSpeed != "0004" {
+		t.Errorf("GetState() = %+v", ps)
+	}
+	env, err := c.GetEnvironment(ctx)
+	if err != nil {
+		t.Fatalf("GetEnvironment() failed: %v", err)
+	}
+	if env.Temperature != "2950" || env.Humidity != "0045" {
+		t.Errorf("GetEnvironment() = %+v", env)
+	}
+}
+
+func TestFanStateSet(t *testing.T) {
+	fan := sim.NewFan(&sim.Opts{
+		EnvInterval: -1,
+		State:       map[string]string{"fmod": dyslink.FanModeOff, "fnsp": "0001"},
+	})
+	addr, stop := serveFan(t, fan)
+	defer stop()
+	c, err := connect(addr, fan.Serial(), fan.PasswordHash(), fan.Model())
+	if err != nil {
+		t.Fatalf("ConnectContext() failed: %v", err)
+	}
+	defer c.Disconnect(0)
+
+	changes := make(chan *dyslink.StateChange, 1)
+	c.OnEvent(dyslink.EventStateChange, func(ev *dyslink.Event) {
+		if sc, ok := ev.StateChange(); ok {
+			changes <- sc
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ps, err := c.SetStateConfirmed(ctx, &dyslink.FanState{FanMode: dyslink.FanModeOn, FanSpeed: "0007"})
+	if err != nil {
+		t.Fatalf("SetStateConfirmed() failed: %v", err)
+	}
+	if ps.FanMode != dyslink.FanModeOn || ps.FanSpeed != "0007" {
+		t.Errorf("SetStateConfirmed() = %+v", ps)
+	}
+	if got := fan.State()["fnsp"]; got != "0007" {
+		t.Errorf("fan speed of the fan = %s, want 0007", got)
+	}
+
+	var sc *dyslink.StateChange
+	select {
+	case sc = <-changes:
+	case <-time.After(time.Second):
+		t.Fatalf("no STATE-CHANGE event")
+	}
+	if fc, ok := sc.Changed("fnsp"); !ok || fc.Old != "0001" || fc.New != "0007" {
+		t.Errorf("change of fnsp = %+v, %v, want 0001->0007", fc, ok)
+	}
+	if sc.Previous.FanMode != dyslink.FanModeOff || sc.Current.FanMode != dyslink.FanModeOn {
+		t.Errorf("fmod changed from %s to %s, want %s to %s",
+			sc.Previous.FanMode, sc.Current.FanMode, dyslink.FanModeOff, dyslink.FanModeOn)
+	}
+	if _, ok := sc.Changed("hmod"); ok {
+		t.Errorf("unchanged field reported as changed: %s", sc)
+	}
+}
+
+func TestFanBootstrap(t *testing.T) {
+	fan := sim.NewFan(&sim.Opts{EnvInterval: -1, Unprovisioned: true})
+	addr, stop := serveFan(t, fan)
+	defer stop()
+
+	// a factory reset fan accepts any login
+	c, err := connect(addr, "setup", "setup", fan.Model())
+	if err != nil {
+		t.Fatalf("ConnectContext() of the unprovisioned fan failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	res, err := c.Provision(ctx, &dyslink.ProvisionOpts{Ssid: "home", Password: "secret"})
+	c.Disconnect(0)
+	if err != nil {
+		t.Fatalf("Provision() failed: %v", err)
+	}
+	if res.Serial != fan.Serial() || res.PasswordHash != fan.PasswordHash() {
+		t.Errorf("Provision() = %+v, want %s and %s", res, fan.Serial(), fan.PasswordHash())
+	}
+	if !fan.Provisioned() {
+		t.Fatalf("fan is not provisioned after Provision()")
+	}
+
+	creds := res.Credentials(fan.Model())
+	c, err = connect(addr, creds.Serial, creds.PasswordHash, creds.Model)
+	if err != nil {
+		t.Fatalf("ConnectContext() with the received credentials failed: %v", err)
+	}
+	c.Disconnect(0)
+	if c, err := connect(addr, "setup", "setup", fan.Model()); err == nil {
+		c.Disconnect(0)
+		t.Errorf("ConnectContext() with the setup login succeeded after provisioning")
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package sim
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsGroup is the mDNS multicast address
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// AdvertiseOpts configures Advertise
+type AdvertiseOpts struct {
+	Address   *net.TCPAddr   // The address of the broker, as announced in the SRV and A records
+	Interface *net.Interface // The interface to use, nil picks the system default
+	GroupAddr *net.UDPAddr   // The multicast group to listen on, defaults to 224.0.0.251:5353
+}
+
+// Advertise answers mDNS queries for dyslink.DiscoveryService like a real fan
+// until the context is done, making the fan visible to dyslink.Discover.
+func (f *Fan) Advertise(ctx context.Context, aopts *AdvertiseOpts) error {
+	group := aopts.GroupAddr
+	if group == nil {
+		group = mdnsGroup
+	}
+	conn, err := net.ListenMulticastUDP("udp4", aopts.Interface, group)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	reply, err := f.mdnsReply(aopts.Address)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !isServiceQuery(buf[:n]) {
+			continue
+		}
+		conn.WriteToUDP(reply, group)
+		if src.Port != group.Port {
+			// legacy (one-shot) querier: also reply directly
+			conn.WriteToUDP(reply, src)
+		}
+	}
+}
+
+// isServiceQuery returns true if packet is a query for dyslink.DiscoveryService
+func isServiceQuery(packet []byte) bool {
+	msg := dnsmessage.Message{}
+	if err := msg.Unpack(packet); err != nil || msg.Header.Response {
+		return false
+	}
+	for _, q := range msg.Questions {
+		if strings.EqualFold(q.Name.String(), dyslink.DiscoveryService) && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+			return true
+		}
+	}
+	return false
+}
+
+// mdnsReply returns the response announcing the fan at given address
+func (f *Fan) mdnsReply(addr *net.TCPAddr) ([]byte, error) {
+	service, err := dnsmessage.NewName(dyslink.DiscoveryService)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(f.opts.Model + "_" + f.opts.Serial + "." + dyslink.DiscoveryService)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(f.opts.Serial + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	var a [4]byte
+	copy(a[:], addr.IP.To4())
+
+	hdr := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{
+			{Header: hdr(service, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: instance}},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: hdr(instance, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: host, Port: uint16(addr.Port)}},
+			{Header: hdr(host, dnsmessage.TypeA), Body: &dnsmessage.AResource{A: a}},
+		},
+	}
+	return msg.Pack()
+}
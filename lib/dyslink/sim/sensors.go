@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adrian-bl/dyslink/lib/dyslink"
+)
+
+// Curve returns the value of a sensor at given time since the start of the simulation.
+// Values use the raw unit of the field, eg. tenths of a Kelvin for tact.
+type Curve func(elapsed time.Duration) float64
+
+// Constant returns a curve with a fixed value
+func Constant(v float64) Curve {
+	return func(time.Duration) float64 { return v }
+}
+
+// Sine returns a curve oscillating around mean
+func Sine(mean float64, amplitude float64, period time.Duration) Curve {
+	return func(elapsed time.Duration) float64 {
+		return mean + amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(period))
+	}
+}
+
+// Ramp returns a curve moving linearly from `from` to `to` and staying there
+func Ramp(from float64, to float64, duration time.Duration) Curve {
+	return func(elapsed time.Duration) float64 {
+		if elapsed >= duration {
+			return to
+		}
+		return from + (to-from)*float64(elapsed)/float64(duration)
+	}
+}
+
+// Steps returns a curve cycling through given values, switching every interval.
+// Without values the curve is constantly 0, a non-positive interval keeps the first value.
+func Steps(interval time.Duration, values ...float64) Curve {
+	if len(values) == 0 {
+		return Constant(0)
+	}
+	if interval <= 0 {
+		return Constant(values[0])
+	}
+	return func(elapsed time.Duration) float64 {
+		return values[int(elapsed/interval)%len(values)]
+	}
+}
+
+// DefaultSensors returns the sensors reported by given model
+func DefaultSensors(model string) map[string]Curve {
+	s := map[string]Curve{
+		"tact": Sine(2950, 15, time.Hour), // ~22C
+		"hact": Sine(45, 5, 2*time.Hour),
+	}
+	caps, _ := dyslink.LookupModel(model)
+	if caps.ProtocolV2 {
+		s["pm25"] = Sine(8, 6, 20*time.Minute)
+		s["pm10"] = Sine(12, 8, 20*time.Minute)
+		s["p25r"] = Sine(8, 6, 20*time.Minute)
+		s["p10r"] = Sine(12, 8, 20*time.Minute)
+		s["va10"] = Sine(20, 10, 30*time.Minute)
+		s["noxl"] = Constant(10)
+		if caps.Formaldehyde {
+			s["hcho"] = Constant(2)
+			s["hchr"] = Constant(2)
+		}
+	} else {
+		s["pact"] = Steps(5*time.Minute, 1, 2, 3, 2)
+		s["vact"] = Constant(1)
+	}
+	return s
+}
+
+// sensorData returns the data of an ENVIRONMENTAL-CURRENT-SENSOR-DATA message
+func sensorData(sensors map[string]Curve, elapsed time.Duration, sleepTimer string) map[string]string {
+	data := map[string]string{"sltm": sleepTimer}
+	for field, curve := range sensors {
+		v := math.Round(curve(elapsed))
+		if v < 0 {
+			v = 0
+		}
+		data[field] = fmt.Sprintf("%04d", int(v))
+	}
+	return data
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSteps(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		values   []float64
+		elapsed  time.Duration
+		want     float64
+	}{
+		{name: "first step", interval: time.Minute, values: []float64{1, 2, 3}, elapsed: 30 * time.Second, want: 1},
+		{name: "second step", interval: time.Minute, values: []float64{1, 2, 3}, elapsed: 90 * time.Second, want: 2},
+		{name: "wraps around", interval: time.Minute, values: []float64{1, 2, 3}, elapsed: 3 * time.Minute, want: 1},
+		{name: "no values", interval: time.Minute, elapsed: time.Hour, want: 0},
+		{name: "zero interval", values: []float64{4, 5}, elapsed: time.Hour, want: 4},
+		{name: "negative interval", interval: -time.Minute, values: []float64{4, 5}, elapsed: time.Hour, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Steps(tt.interval, tt.values...)(tt.elapsed); got != tt.want {
+				t.Errorf("Steps(%v, %v)(%v) = %v, want %v", tt.interval, tt.values, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
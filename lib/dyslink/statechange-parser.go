@@ -13,29 +13,86 @@ package dyslink
 import (
 	"fmt"
 	"github.com/mitchellh/mapstructure"
+	"sort"
+	"strings"
 )
 
+// Known values of the mode-reason field, telling who triggered a change
+const (
+	ModeReasonLocalApp      = "LAPP" // app, connected via the local network
+	ModeReasonRemoteApp     = "RAPP" // app, connected via the cloud
+	ModeReasonRemoteControl = "PRC"  // infrared remote control
+	ModeReasonDeviceUI      = "PUI"  // buttons on the device
+	ModeReasonSchedule      = "LSCH" // a schedule stored on the device
+)
+
+// FieldChange holds the previous and new value of a changed field
+type FieldChange struct {
+	Field string // The field as sent by the device, eg. `fnsp`
+	Old   string
+	New   string
+}
+
+// StateChange is the decoded form of a STATE-CHANGE message
+type StateChange struct {
+	Reason   string        // One of the ModeReason* constants
+	Changes  []FieldChange // All fields whose value changed, sorted by field
+	Previous *ProductState
+	Current  *ProductState
+}
+
+// Changed returns the change of given field, ok is false
+// if the field did not change.
+func (sc *StateChange) Changed(field string) (fc FieldChange, ok bool) {
+	for _, fc := range sc.Changes {
+		if fc.Field == field {
+			return fc, true
+		}
+	}
+	return fc, false
+}
+
+func (sc *StateChange) String() string {
+	parts := make([]string, 0, len(sc.Changes))
+	for _, fc := range sc.Changes {
+		parts = append(parts, fmt.Sprintf("%s %s->%s", fc.Field, fc.Old, fc.New))
+	}
+	return fmt.Sprintf("%s via %s", strings.Join(parts, ", "), sc.Reason)
+}
+
 // parseStateChangePayload is a butt ugly version to parse
 // unsolicited status changes sent to us
-func parseStateChangePayload(p interface{}) (*ProductState, error) {
+func parseStateChangePayload(p interface{}, reason string) (*StateChange, error) {
 	m, found := p.(map[string]interface{})
 	if found == false {
 		return nil, fmt.Errorf("Unexpected interface type")
 	}
 
-	state := &ProductState{}
+	sc := &StateChange{Reason: reason, Previous: &ProductState{}, Current: &ProductState{}}
+	oldson := make(map[string]string)
 	newson := make(map[string]string)
 
 	for key, intf := range m {
 		ilist, found := intf.([]interface{})
 		if found == true && len(ilist) == 2 {
-			str, found := ilist[1].(string)
+			ostr, _ := ilist[0].(string)
+			nstr, found := ilist[1].(string)
 			if found == true {
-				newson[key] = str
+				oldson[key] = ostr
+				newson[key] = nstr
+				if ostr != nstr {
+					sc.Changes = append(sc.Changes, FieldChange{Field: key, Old: ostr, New: nstr})
+				}
 			}
 		}
 	}
+	sort.Slice(sc.Changes, func(i, j int) bool { return sc.Changes[i].Field < sc.Changes[j].Field })
 
-	err := mapstructure.Decode(newson, &state)
-	return state, err
+	if err := mapstructure.Decode(oldson, &sc.Previous); err != nil {
+		return sc, err
+	}
+	err := mapstructure.Decode(newson, &sc.Current)
+	sc.Previous.normalize()
+	sc.Current.normalize()
+	return sc, err
 }
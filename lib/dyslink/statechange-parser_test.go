@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStateChange(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      string
+		wantReason   string
+		wantChanges  []FieldChange
+		wantPrevious ProductState // the fields to compare, see below
+		wantCurrent  ProductState
+	}{
+		{
+			name: "speed via remote control",
+			payload: `{"msg":"STATE-CHANGE","time":"2019-03-17T12:00:00.000Z","mode-reason":"PRC","state-reason":"MODE",` +
+				`"product-state":{"fmod":["FAN","FAN"],"fnsp":["0001","0007"],"oson":["ON","ON"]}}`,
+			wantReason:   ModeReasonRemoteControl,
+			wantChanges:  []FieldChange{{Field: "fnsp", Old: "0001", New: "0007"}},
+			wantPrevious: ProductState{FanMode: FanModeOn, FanSpeed: "0001", Oscillate: OscillateOn},
+			wantCurrent:  ProductState{FanMode: FanModeOn, FanSpeed: "0007", Oscillate: OscillateOn},
+		},
+		{
+			name: "several fields via the app",
+			payload: `{"msg":"STATE-CHANGE","mode-reason":"LAPP",` +
+				`"product-state":{"sltm":["OFF","0030"],"fmod":["OFF","AUTO"],"nmod":["OFF","ON"],"fnsp":["0004","AUTO"]}}`,
+			wantReason: ModeReasonLocalApp,
+			wantChanges: []FieldChange{
+				{Field: "fmod", Old: "OFF", New: "AUTO"},
+				{Field: "fnsp", Old: "0004", New: "AUTO"},
+				{Field: "nmod", Old: "OFF", New: "ON"},
+				{Field: "sltm", Old: "OFF", New: "0030"},
+			},
+			wantPrevious: ProductState{FanMode: FanModeOff, FanSpeed: "0004"},
+			wantCurrent:  ProductState{FanMode: FanModeAuto, FanSpeed: FanSpeedAuto},
+		},
+		{
+			name: "v2 oscillation via the device",
+			payload: `{"msg":"STATE-CHANGE","mode-reason":"PUI",` +
+				`"product-state":{"fpwr":["ON","ON"],"auto":["OFF","OFF"],"oson":["OIOF","OION"]}}`,
+			wantReason:   ModeReasonDeviceUI,
+			wantChanges:  []FieldChange{{Field: "oson", Old: "OIOF", New: "OION"}},
+			wantPrevious: ProductState{FanMode: FanModeOn, Oscillate: OscillateOff},
+			wantCurrent:  ProductState{FanMode: FanModeOn, Oscillate: OscillateOn},
+		},
+		{
+			name: "nothing changed",
+			payload: `{"msg":"STATE-CHANGE","mode-reason":"LSCH",` +
+				`"product-state":{"fmod":["FAN","FAN"],"fnsp":["0002","0002"]}}`,
+			wantReason:   ModeReasonSchedule,
+			wantPrevious: ProductState{FanMode: FanModeOn, FanSpeed: "0002"},
+			wantCurrent:  ProductState{FanMode: FanModeOn, FanSpeed: "0002"},
+		},
+		{
+			name: "malformed fields are ignored",
+			payload: `{"msg":"STATE-CHANGE","mode-reason":"RAPP",` +
+				`"product-state":{"fmod":"FAN","fnsp":["0003"],"oson":["OFF","ON","OFF"],"nmod":["OFF","ON"]}}`,
+			wantReason:  ModeReasonRemoteApp,
+			wantChanges: []FieldChange{{Field: "nmod", Old: "OFF", New: "ON"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := decodeMessage([]byte(tt.payload))
+			if ev.Kind != EventStateChange || ev.Error != nil {
+				t.Fatalf("decodeMessage() = %+v, want a state change event", ev)
+			}
+			sc, ok := ev.StateChange()
+			if !ok || sc == nil {
+				t.Fatalf("StateChange() = %v, %v", sc, ok)
+			}
+			if sc.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", sc.Reason, tt.wantReason)
+			}
+			if !reflect.DeepEqual(sc.Changes, tt.wantChanges) {
+				t.Errorf("Changes = %+v, want %+v", sc.Changes, tt.wantChanges)
+			}
+			for _, c := range tt.wantChanges {
+				if got, ok := sc.Changed(c.Field); !ok || got != c {
+					t.Errorf("Changed(%s) = %+v, %v, want %+v", c.Field, got, ok, c)
+				}
+			}
+			if _, ok := sc.Changed("hmod"); ok {
+				t.Errorf("Changed(hmod) = true for an unchanged field")
+			}
+			states := []struct {
+				name string
+				got  *ProductState
+				want ProductState
+			}{
+				{"Previous", sc.Previous, tt.wantPrevious},
+				{"Current", sc.Current, tt.wantCurrent},
+			}
+			for _, s := range states {
+				if s.got.FanMode != s.want.FanMode || s.got.FanSpeed != s.want.FanSpeed || s.got.Oscillate != s.want.Oscillate {
+					t.Errorf("%s = %+v, want %+v", s.name, s.got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStateChangeString(t *testing.T) {
+	sc := &StateChange{
+		Reason: ModeReasonRemoteControl,
+		Changes: []FieldChange{
+			{Field: "fnsp", Old: "0001", New: "0007"},
+			{Field: "oson", Old: "OFF", New: "ON"},
+		},
+	}
+	if got, want := sc.String(), "fnsp 0001->0007, oson OFF->ON via PRC"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -23,6 +23,10 @@ const (
 	MessageAuthoriseUserRequest = "AUTHORISE-USER-REQUEST"
 	MessageCloseAccessPoint     = "CLOSE-ACCESS-POINT"
 	MessageDeviceCredentials    = "DEVICE-CREDENTIALS"
+	MessageCurrentFaults        = "CURRENT-FAULTS"      // incoming fault data
+	MessageProvisioningFailed   = "PROVISIONING-FAILED" // incoming: a bootstrap step failed
+	MessageRequestCurrentState  = "REQUEST-CURRENT-STATE"
+	MessageRequestFaults        = "REQUEST-CURRENT-FAULTS"
 )
 
 // States of fan modules
@@ -44,6 +48,16 @@ const (
 	StandbyMonitorOff = "OFF"
 	FocusedModeOn     = "ON"
 	FocusedModeOff    = "OFF"
+	FanPowerOn        = "ON"  // v2 protocol: replaces FanModeOn
+	FanPowerOff       = "OFF" // v2 protocol: replaces FanModeOff
+	AutoModeOn        = "ON"  // v2 protocol: replaces FanModeAuto
+	AutoModeOff       = "OFF"
+	FrontAirflowOn    = "ON"
+	FrontAirflowOff   = "OFF"
+	AngleCustom       = "CUST" // v2 protocol: oscillation angles set via osal/osau
+	OscillateOnV2     = "OION" // v2 protocol: replaces OscillateOn
+	OscillateOffV2    = "OIOF" // v2 protocol: replaces OscillateOff
+	OscillationIdle   = "IDLE" // v2 protocol: oscillation is enabled but the fan is off
 )
 
 // The command-json sent to the device
@@ -74,6 +88,12 @@ type FanState struct {
 	HeatMode          string `json:"hmod,omitempty"`
 	HeatTarget        string `json:"hmax,omitempty"`
 	FocusedMode       string `json:"ffoc,omitempty"`
+	FanPower          string `json:"fpwr,omitempty"` // v2 protocol
+	AutoMode          string `json:"auto,omitempty"` // v2 protocol
+	OscillationLow    string `json:"osal,omitempty"` // v2 protocol: lower oscillation angle
+	OscillationHigh   string `json:"osau,omitempty"` // v2 protocol: upper oscillation angle
+	AngleCombination  string `json:"ancp,omitempty"` // v2 protocol
+	FrontAirflow      string `json:"fdir,omitempty"` // v2 protocol
 }
 
 // A product status message
@@ -94,10 +114,86 @@ type ProductState struct {
 	HeatTarget        string `mapstructure:"hmax"`
 	FilterLife        string `mapstructure:"filf"`
 	FocusedMode       string `mapstructure:"ffoc"`
-	UnknownErcd       string `mapstructure:"ercd"`
-	UnknownWacd       string `mapstructure:"wacd"`
+	ErrorCode         string `mapstructure:"ercd"` // error code or NONE, see Faults
+	WarningCode       string `mapstructure:"wacd"` // warning code or NONE, see Faults
 	UnknownRhtm       string `mapstructure:"rhtm"`
 	UnknownTilt       string `mapstructure:"tilt"`
+	FanPower          string `mapstructure:"fpwr"` // v2 protocol
+	AutoMode          string `mapstructure:"auto"` // v2 protocol
+	OscillationState  string `mapstructure:"oscs"` // v2 protocol
+	OscillationLow    string `mapstructure:"osal"` // v2 protocol
+	OscillationHigh   string `mapstructure:"osau"` // v2 protocol
+	AngleCombination  string `mapstructure:"ancp"` // v2 protocol
+	FrontAirflow      string `mapstructure:"fdir"` // v2 protocol
+	CarbonFilterLife  string `mapstructure:"cflr"` // v2 protocol, in percent
+	HepaFilterLife    string `mapstructure:"hflr"` // v2 protocol, in percent
+}
+
+// normalize fills in the fields of the other protocol version, so that
+// consumers can use the same fields for both old and new devices
+func (p *ProductState) normalize() {
+	p.Oscillate = normalizeOscillation(p.Oscillate)
+	p.OscillationState = normalizeOscillation(p.OscillationState)
+	if p.Oscillate == "" && p.OscillationState != "" {
+		p.Oscillate = onOff(p.OscillationState != OscillateOff, OscillateOn, OscillateOff)
+	}
+	if p.OscillationState == "" && p.Oscillate != "" {
+		p.OscillationState = p.Oscillate
+	}
+
+	if p.FanMode == "" && p.FanPower != "" {
+		switch {
+		case p.FanPower == FanPowerOff:
+			p.FanMode = FanModeOff
+		case p.AutoMode == AutoModeOn:
+			p.FanMode = FanModeAuto
+		default:
+			p.FanMode = FanModeOn
+		}
+	}
+	if p.FanPower == "" && p.FanMode != "" {
+		p.FanPower = onOff(p.FanMode != FanModeOff, FanPowerOn, FanPowerOff)
+		p.AutoMode = onOff(p.FanMode == FanModeAuto, AutoModeOn, AutoModeOff)
+	}
+}
+
+// normalizeOscillation maps the v2 spelling of an oscillation value to OscillateOn / OscillateOff
+func normalizeOscillation(v string) string {
+	switch v {
+	case OscillateOnV2:
+		return OscillateOn
+	case OscillateOffV2:
+		return OscillateOff
+	}
+	return v
+}
+
+// adaptState translates the legacy FanMode and Oscillate values of a
+// state into the fields understood by given model
+func adaptState(model string, state *FanState) *FanState {
+	caps, _ := LookupModel(model)
+	if !caps.ProtocolV2 || (state.FanMode == "" && state.Oscillate == "") {
+		return state
+	}
+	s := *state
+	switch s.FanMode {
+	case FanModeOff:
+		s.FanPower = FanPowerOff
+	case FanModeOn:
+		s.FanPower = FanPowerOn
+		s.AutoMode = AutoModeOff
+	case FanModeAuto:
+		s.FanPower = FanPowerOn
+		s.AutoMode = AutoModeOn
+	}
+	s.FanMode = ""
+	switch s.Oscillate {
+	case OscillateOn:
+		s.Oscillate = OscillateOnV2
+	case OscillateOff:
+		s.Oscillate = OscillateOffV2
+	}
+	return &s
 }
 
 // The current environment data as reported by the device
@@ -107,6 +203,14 @@ type EnvironmentState struct {
 	Particle    string `mapstructure:"pact"`
 	UnknownVact string `mapstructure:"vact"`
 	SleepTimer  string `mapstructure:"sltm"`
+	PM25        string `mapstructure:"pm25"` // PM2.5 in ug/m3
+	PM10        string `mapstructure:"pm10"` // PM10 in ug/m3
+	PM25Revised string `mapstructure:"p25r"` // PM2.5 in ug/m3 (newer firmware)
+	PM10Revised string `mapstructure:"p10r"` // PM10 in ug/m3 (newer firmware)
+	VOCIndex    string `mapstructure:"va10"` // VOC index * 10
+	NO2Index    string `mapstructure:"noxl"` // NO2 index * 10
+	HCHO        string `mapstructure:"hcho"` // formaldehyde in mg/m3 * 1000
+	HCHORevised string `mapstructure:"hchr"` // formaldehyde in mg/m3 * 1000 (newer firmware)
 }
 
 // Reply for a credentials request (note: this is sent in a commandHeader)
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"testing"
+)
+
+func TestProductStateNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   ProductState
+		want ProductState
+	}{
+		{
+			name: "v1 fan mode",
+			in:   ProductState{FanMode: FanModeAuto, Oscillate: OscillateOn},
+			want: ProductState{FanMode: FanModeAuto, FanPower: FanPowerOn, AutoMode: AutoModeOn, Oscillate: OscillateOn, OscillationState: OscillateOn},
+		},
+		{
+			name: "v2 power off",
+			in:   ProductState{FanPower: FanPowerOff, AutoMode: AutoModeOn, Oscillate: OscillateOffV2, OscillationState: OscillateOff},
+			want: ProductState{FanMode: FanModeOff, FanPower: FanPowerOff, AutoMode: AutoModeOn, Oscillate: OscillateOff, OscillationState: OscillateOff},
+		},
+		{
+			name: "v2 oscillation idle",
+			in:   ProductState{FanPower: FanPowerOn, AutoMode: AutoModeOff, Oscillate: OscillateOnV2, OscillationState: OscillationIdle},
+			want: ProductState{FanMode: FanModeOn, FanPower: FanPowerOn, AutoMode: AutoModeOff, Oscillate: OscillateOn, OscillationState: OscillationIdle},
+		},
+		{
+			name: "oscillation state only",
+			in:   ProductState{OscillationState: OscillateOnV2},
+			want: ProductState{Oscillate: OscillateOn, OscillationState: OscillateOn},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.in
+			got.normalize()
+			if got != tt.want {
+				t.Errorf("normalize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptState(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		in    FanState
+		want  FanState
+	}{
+		{
+			name:  "v1 is unchanged",
+			model: TypeModelN475,
+			in:    FanState{FanMode: FanModeAuto, Oscillate: OscillateOn},
+			want:  FanState{FanMode: FanModeAuto, Oscillate: OscillateOn},
+		},
+		{
+			name:  "unknown model is unchanged",
+			model: "999",
+			in:    FanState{FanMode: FanModeOff, Oscillate: OscillateOff},
+			want:  FanState{FanMode: FanModeOff, Oscillate: OscillateOff},
+		},
+		{
+			name:  "v2 fan mode",
+			model: TypeModelN438,
+			in:    FanState{FanMode: FanModeOn, FanSpeed: "0005"},
+			want:  FanState{FanPower: FanPowerOn, AutoMode: AutoModeOff, FanSpeed: "0005"},
+		},
+		{
+			name:  "v2 oscillation",
+			model: TypeModelN438,
+			in:    FanState{Oscillate: OscillateOn},
+			want:  FanState{Oscillate: OscillateOnV2},
+		},
+		{
+			name:  "v2 oscillation off",
+			model: TypeModelN527,
+			in:    FanState{FanMode: FanModeAuto, Oscillate: OscillateOff},
+			want:  FanState{FanPower: FanPowerOn, AutoMode: AutoModeOn, Oscillate: OscillateOffV2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.in
+			got := adaptState(tt.model, &in)
+			if *got != tt.want {
+				t.Errorf("adaptState() = %+v, want %+v", got, &tt.want)
+			}
+			if in != tt.in {
+				t.Errorf("adaptState() modified its input: %+v", in)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"sync"
+	"time"
+)
+
+// tokenPollInterval is the interval at which waitToken checks the context
+const tokenPollInterval = 100 * time.Millisecond
+
+// waitToken waits until the broker completed given token or the
+// context expires, whichever happens first.
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	for !token.WaitTimeout(tokenPollInterval) {
+		if ctx.Err() != nil {
+			return fmt.Errorf("waiting for broker: %w", ctx.Err())
+		}
+	}
+	return token.Error()
+}
+
+// Transport moves messages between the client and the broker of a device.
+// The default transport uses paho.mqtt.golang, see ClientOpts.Transport.
+type Transport interface {
+	// Connect establishes the connection and keeps it alive until Disconnect is called
+	Connect(context.Context, *TransportHandlers) error
+	// Disconnect closes the connection, waiting up to quiesce milliseconds
+	Disconnect(quiesce uint)
+	// Publish delivers a message to given topic
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe subscribes to given topic. Subscriptions do not need to survive a reconnect.
+	Subscribe(ctx context.Context, topic string) error
+}
+
+// TransportHandlers are the callbacks of the client invoked by a Transport
+type TransportHandlers struct {
+	Message        func(topic string, payload []byte) // A message was received
+	Connected      func()                             // Called after each successful (re)connect
+	ConnectionLost func(error)                        // The connection was lost and will be re-established
+}
+
+// pahoTransport is the default transport, connecting to the local broker
+// of the device or, if configured, the cloud endpoint.
+// Local connections are re-established by paho itself. Cloud connections
+// are re-established by reconnectCloud, as the token sent in the http
+// headers must be refreshed first.
+type pahoTransport struct {
+	opts   *ClientOpts
+	logger Logger
+
+	mu       sync.Mutex
+	client   mqtt.Client
+	h        *TransportHandlers
+	cloud    *CloudOpts    // the cloud credentials of the next (re)connect
+	stop     chan struct{} // closed by Disconnect
+	stopOnce sync.Once
+}
+
+// newPahoTransport returns a paho transport for given options,
+// logger is the effective logger of the client.
+func newPahoTransport(opts *ClientOpts, logger Logger) *pahoTransport {
+	return &pahoTransport{opts: opts, logger: logger, stop: make(chan struct{})}
+}
+
+func (t *pahoTransport) Connect(ctx context.Context, h *TransportHandlers) error {
+	if _, nop := t.logger.(nopLogger); !nop {
+		installMqttLogger(t.logger)
+	}
+	t.mu.Lock()
+	t.h = h
+	t.cloud = t.opts.Cloud
+	t.mu.Unlock()
+	return t.connect(ctx)
+}
+
+// connect creates a new paho client and connects it
+func (t *pahoTransport) connect(ctx context.Context) error {
+	mqttClient := mqtt.NewClient(t.mqttOptions())
+	t.mu.Lock()
+	t.client = mqttClient
+	t.mu.Unlock()
+	if err := waitToken(ctx, mqttClient.Connect()); err != nil {
+		mqttClient.Disconnect(0)
+		return err
+	}
+	return nil
+}
+
+// mqttOptions returns the paho options of the next connection
+func (t *pahoTransport) mqttOptions() *mqtt.ClientOptions {
+	t.mu.Lock()
+	h, cloud := t.h, t.cloud
+	t.mu.Unlock()
+
+	mqttOpts := mqtt.NewClientOptions()
+	if cloud != nil {
+		cloud.apply(mqttOpts)
+	} else {
+		mqttOpts.AddBroker(t.opts.DeviceAddress)
+		mqttOpts.SetUsername(t.opts.Username)
+		if t.opts.PasswordHash != "" {
+			mqttOpts.SetPassword(t.opts.PasswordHash)
+		} else {
+			mqttOpts.SetPassword(encodePassword(t.opts.Password))
+		}
+	}
+	mqttOpts.SetDefaultPublishHandler(func(mclient mqtt.Client, msg mqtt.Message) {
+		h.Message(msg.Topic(), msg.Payload())
+	})
+	mqttOpts.SetAutoReconnect(cloud == nil)
+	mqttOpts.SetMaxReconnectInterval(t.maxReconnectInterval())
+	mqttOpts.SetOnConnectHandler(func(mclient mqtt.Client) {
+		h.Connected()
+	})
+	mqttOpts.SetConnectionLostHandler(func(mclient mqtt.Client, err error) {
+		h.ConnectionLost(err)
+		if cloud != nil {
+			go t.reconnectCloud()
+		}
+	})
+	return mqttOpts
+}
+
+func (t *pahoTransport) maxReconnectInterval() time.Duration {
+	if t.opts.MaxReconnectInterval > 0 {
+		return t.opts.MaxReconnectInterval
+	}
+	return DefaultMaxReconnectInterval
+}
+
+// reconnectCloud refreshes the cloud credentials (see CloudOpts.Refresh) and
+// reconnects, retrying with a backoff until it succeeds or Disconnect is called.
+func (t *pahoTransport) reconnectCloud() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := time.Second
+	for {
+		err := t.refreshCloud(ctx)
+		if err == nil {
+			cctx, ccancel := context.WithTimeout(ctx, DefaultReplyTimeout)
+			err = t.connect(cctx)
+			ccancel()
+		}
+		if ctx.Err() != nil {
+			// Disconnect was called while we were connecting
+			t.getClient().Disconnect(0)
+			return
+		}
+		if err == nil {
+			return
+		}
+		t.logger.Log(LevelWarn, fmt.Sprintf("reconnect failed, retrying in %s: %v", backoff, err), Fields{FieldDevice: t.opts.Username})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > t.maxReconnectInterval() {
+			backoff = t.maxReconnectInterval()
+		}
+	}
+}
+
+// refreshCloud replaces the cloud credentials with fresh ones, if possible
+func (t *pahoTransport) refreshCloud(ctx context.Context) error {
+	refresh := t.opts.Cloud.Refresh
+	if refresh == nil {
+		return nil
+	}
+	cloud, err := refresh(ctx)
+	if err != nil {
+		return fmt.Errorf("refreshing cloud credentials: %w", err)
+	}
+	t.mu.Lock()
+	t.cloud = cloud
+	t.mu.Unlock()
+	return nil
+}
+
+// getClient returns the current paho client
+func (t *pahoTransport) getClient() mqtt.Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.client
+}
+
+func (t *pahoTransport) Disconnect(quiesce uint) {
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.getClient().Disconnect(quiesce)
+}
+
+func (t *pahoTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	return waitToken(ctx, t.getClient().Publish(topic, 1, false, payload))
+}
+
+func (t *pahoTransport) Subscribe(ctx context.Context, topic string) error {
+	return waitToken(ctx, t.getClient().Subscribe(topic, 0, nil))
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Speed is a fan speed between 1 and 10 or SpeedAuto
+type Speed int
+
+// SpeedAuto means that the device picks the speed on its own
+const SpeedAuto Speed = -1
+
+// IsAuto returns true if the speed is controlled by the device
+func (s Speed) IsAuto() bool {
+	return s == SpeedAuto
+}
+
+func (s Speed) String() string {
+	if s.IsAuto() {
+		return FanSpeedAuto
+	}
+	return strconv.Itoa(int(s))
+}
+
+// MarshalJSON encodes the speed as number or as "AUTO"
+func (s Speed) MarshalJSON() ([]byte, error) {
+	if s.IsAuto() {
+		return json.Marshal(FanSpeedAuto)
+	}
+	return json.Marshal(int(s))
+}
+
+// Temperature is a temperature in 1/10 Kelvin, as used by the device
+type Temperature int
+
+// Kelvin returns the temperature in Kelvin
+func (t Temperature) Kelvin() float64 {
+	return float64(t) / 10
+}
+
+// Celsius returns the temperature in degree Celsius
+func (t Temperature) Celsius() float64 {
+	return float64(t)/10 - 273.15
+}
+
+// Fahrenheit returns the temperature in degree Fahrenheit
+func (t Temperature) Fahrenheit() int {
+	return ConvertTempToFahr(int(t))
+}
+
+// ProductValues is a typed view of a ProductState.
+// Fields which could not be parsed are left at their zero value.
+type ProductValues struct {
+	Raw               *ProductState `json:"-"`
+	FanMode           string        // One of the FanMode* constants
+	FanRunning        bool          // true if the fan is currently spinning
+	FanSpeed          Speed
+	Oscillate         bool
+	SleepTimer        time.Duration // zero if the sleep timer is off
+	StandbyMonitoring bool
+	QualityTarget     string // One of the Quality* constants
+	NightMode         bool
+	HeatMode          bool
+	Heating           bool // true if the device is currently heating
+	HeatTarget        Temperature
+	FilterLife        int // remaining filter life in hours
+	FocusedMode       bool
+	Power             bool // true if the fan is switched on
+	AutoMode          bool
+	OscillationLow    int  // lower oscillation angle in degree (v2 only)
+	OscillationHigh   int  // upper oscillation angle in degree (v2 only)
+	FrontAirflow      bool // (v2 only)
+	CarbonFilterLife  int  // remaining carbon filter life in percent (v2 only)
+	HepaFilterLife    int  // remaining HEPA filter life in percent (v2 only)
+}
+
+// Values returns a typed view of the product state
+func (p *ProductState) Values() *ProductValues {
+	return &ProductValues{
+		Raw:               p,
+		FanMode:           p.FanMode,
+		FanRunning:        p.FanState == FanModeOn,
+		FanSpeed:          parseSpeed(p.FanSpeed),
+		Oscillate:         parseSwitch(p.Oscillate),
+		SleepTimer:        parseSleepTimer(p.SleepTimer),
+		StandbyMonitoring: parseSwitch(p.StandbyMonitoring),
+		QualityTarget:     p.QualityTarget,
+		NightMode:         parseSwitch(p.NightMode),
+		HeatMode:          p.HeatMode == HeatModeOn,
+		Heating:           p.HeatState == HeatModeOn,
+		HeatTarget:        Temperature(parseInt(p.HeatTarget)),
+		FilterLife:        parseInt(p.FilterLife),
+		FocusedMode:       parseSwitch(p.FocusedMode),
+		Power:             parseSwitch(p.FanPower),
+		AutoMode:          parseSwitch(p.AutoMode),
+		OscillationLow:    parseInt(p.OscillationLow),
+		OscillationHigh:   parseInt(p.OscillationHigh),
+		FrontAirflow:      parseSwitch(p.FrontAirflow),
+		CarbonFilterLife:  parseInt(p.CarbonFilterLife),
+		HepaFilterLife:    parseInt(p.HepaFilterLife),
+	}
+}
+
+// EnvironmentValues is a typed view of an EnvironmentState.
+// Fields which could not be parsed are left at their zero value.
+type EnvironmentValues struct {
+	Raw         *EnvironmentState `json:"-"`
+	Temperature Temperature
+	Humidity    int // relative humidity in percent
+	Particle    int
+	VOC         int           // volatile organic compounds level 0-9 (vact, older devices), see VOCIndex
+	SleepTimer  time.Duration // zero if the sleep timer is off
+	PM25        SensorReading // ug/m3
+	PM10        SensorReading // ug/m3
+	PM25Revised SensorReading // ug/m3
+	PM10Revised SensorReading // ug/m3
+	VOCIndex    SensorReading // VOC index (va10, newer devices)
+	NO2Index    SensorReading // NO2 index
+	HCHO        SensorReading // mg/m3
+	HCHORevised SensorReading // mg/m3
+}
+
+// SensorReading is the value of a sensor which might not
+// be present in all devices
+type SensorReading struct {
+	Value        float64
+	Present      bool // false if the device did not report this sensor
+	Initializing bool // true while the sensor is still warming up
+}
+
+// Valid returns true if Value holds an actual reading
+func (r SensorReading) Valid() bool {
+	return r.Present && !r.Initializing
+}
+
+// Values returns a typed view of the environment state
+func (e *EnvironmentState) Values() *EnvironmentValues {
+	return &EnvironmentValues{
+		Raw:         e,
+		Temperature: Temperature(parseInt(e.Temperature)),
+		Humidity:    parseInt(e.Humidity),
+		Particle:    parseInt(e.Particle),
+		VOC:         parseInt(e.UnknownVact),
+		SleepTimer:  parseSleepTimer(e.SleepTimer),
+		PM25:        parseSensor(e.PM25, 1),
+		PM10:        parseSensor(e.PM10, 1),
+		PM25Revised: parseSensor(e.PM25Revised, 1),
+		PM10Revised: parseSensor(e.PM10Revised, 1),
+		VOCIndex:    parseSensor(e.VOCIndex, 0.1),
+		NO2Index:    parseSensor(e.NO2Index, 0.1),
+		HCHO:        parseSensor(e.HCHO, 0.001),
+		HCHORevised: parseSensor(e.HCHORevised, 0.001),
+	}
+}
+
+// parseSensor parses a sensor value and multiplies it with scale.
+// Missing sensors are reported as "", "OFF" or "NONE", sensors
+// which are warming up as "INIT".
+func parseSensor(s string, scale float64) SensorReading {
+	switch s {
+	case "", "OFF", "NONE":
+		return SensorReading{}
+	case "INIT":
+		return SensorReading{Present: true, Initializing: true}
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return SensorReading{}
+	}
+	return SensorReading{Value: float64(i) * scale, Present: true}
+}
+
+// parseInt parses a (zero padded) number, returns 0 on error
+func parseInt(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// parseSwitch returns true for "ON"
+func parseSwitch(s string) bool {
+	return s == "ON"
+}
+
+// parseSpeed parses a fan speed, which is either a number or "AUTO"
+func parseSpeed(s string) Speed {
+	if s == FanSpeedAuto {
+		return SpeedAuto
+	}
+	return Speed(parseInt(s))
+}
+
+// parseSleepTimer parses the remaining minutes of the sleep timer
+func parseSleepTimer(s string) time.Duration {
+	return time.Duration(parseInt(s)) * time.Minute
+}
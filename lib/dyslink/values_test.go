@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSpeed(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     Speed
+		wantAuto bool
+		wantStr  string
+		wantJSON string
+	}{
+		{"0001", 1, false, "1", "1"},
+		{"0010", 10, false, "10", "10"},
+		{"AUTO", SpeedAuto, true, "AUTO", `"AUTO"`},
+		{"", 0, false, "0", "0"},
+	}
+
+	for _, tt := range tests {
+		got := parseSpeed(tt.in)
+		if got != tt.want || got.IsAuto() != tt.wantAuto || got.String() != tt.wantStr {
+			t.Errorf("parseSpeed(%q) = %v (auto=%v, %q), want %v (auto=%v, %q)", tt.in, int(got), got.IsAuto(), got.String(), int(tt.want), tt.wantAuto, tt.wantStr)
+		}
+		js, err := got.MarshalJSON()
+		if err != nil || string(js) != tt.wantJSON {
+			t.Errorf("MarshalJSON(%v) = %s, %v, want %s", int(got), js, err, tt.wantJSON)
+		}
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantKelvin  float64
+		wantCelsius float64
+		wantFahr    int
+	}{
+		{"2981", 298.1, 24.95, 77},
+		{"2732", 273.2, 0.05, 32},
+		{"2931", 293.1, 19.95, 68},
+		{"0000", 0, -273.15, -461},
+	}
+
+	for _, tt := range tests {
+		temp := Temperature(parseInt(tt.in))
+		if math.Abs(temp.Kelvin()-tt.wantKelvin) > 1e-9 || math.Abs(temp.Celsius()-tt.wantCelsius) > 1e-9 || temp.Fahrenheit() != tt.wantFahr {
+			t.Errorf("Temperature(%s) = %vK / %vC / %vF, want %vK / %vC / %vF", tt.in,
+				temp.Kelvin(), temp.Celsius(), temp.Fahrenheit(), tt.wantKelvin, tt.wantCelsius, tt.wantFahr)
+		}
+	}
+}
+
+func TestProductValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		state ProductState
+		want  ProductValues
+	}{
+		{
+			name:  "manual speed",
+			state: ProductState{FanMode: FanModeOn, FanState: FanModeOn, FanSpeed: "0004", Oscillate: OscillateOn, SleepTimer: "0045", NightMode: "ON", FilterLife: "4300"},
+			want:  ProductValues{FanMode: FanModeOn, FanRunning: true, FanSpeed: 4, Oscillate: true, SleepTimer: 45 * time.Minute, NightMode: true, FilterLife: 4300},
+		},
+		{
+			name:  "auto speed and sleep timer off",
+			state: ProductState{FanMode: FanModeAuto, FanState: FanModeOff, FanSpeed: FanSpeedAuto, Oscillate: OscillateOff, SleepTimer: "OFF"},
+			want:  ProductValues{FanMode: FanModeAuto, FanSpeed: SpeedAuto},
+		},
+		{
+			name:  "heating",
+			state: ProductState{HeatMode: HeatModeOn, HeatState: HeatModeOn, HeatTarget: "2981", FocusedMode: "ON"},
+			want:  ProductValues{HeatMode: true, Heating: true, HeatTarget: 2981, FocusedMode: true},
+		},
+		{
+			name: "v2",
+			state: ProductState{FanPower: FanPowerOn, AutoMode: AutoModeOn, FanSpeed: FanSpeedAuto, OscillationLow: "0045", OscillationHigh: "0315",
+				FrontAirflow: "ON", CarbonFilterLife: "0080", HepaFilterLife: "0095"},
+			want: ProductValues{Power: true, AutoMode: true, FanSpeed: SpeedAuto, OscillationLow: 45, OscillationHigh: 315,
+				FrontAirflow: true, CarbonFilterLife: 80, HepaFilterLife: 95},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.state.Values()
+			if got.Raw != &tt.state {
+				t.Errorf("Raw = %p, want %p", got.Raw, &tt.state)
+			}
+			got.Raw = nil
+			if *got != tt.want {
+				t.Errorf("Values() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentValues(t *testing.T) {
+	tests := []struct {
+		name string
+		env  EnvironmentState
+		want EnvironmentValues
+	}{
+		{
+			name: "older device",
+			env:  EnvironmentState{Temperature: "2981", Humidity: "0045", Particle: "0003", UnknownVact: "0002", SleepTimer: "0030"},
+			want: EnvironmentValues{Temperature: 2981, Humidity: 45, Particle: 3, VOC: 2, SleepTimer: 30 * time.Minute},
+		},
+		{
+			name: "sleep timer off and sensors absent",
+			env:  EnvironmentState{Temperature: "2981", SleepTimer: "OFF", PM25: "NONE", NO2Index: "OFF"},
+			want: EnvironmentValues{Temperature: 2981},
+		},
+		{
+			name: "sensors initializing",
+			env:  EnvironmentState{Humidity: "INIT", PM25: "INIT", VOCIndex: "INIT", HCHO: "INIT"},
+			want: EnvironmentValues{
+				PM25:     SensorReading{Present: true, Initializing: true},
+				VOCIndex: SensorReading{Present: true, Initializing: true},
+				HCHO:     SensorReading{Present: true, Initializing: true},
+			},
+		},
+		{
+			name: "newer device",
+			env:  EnvironmentState{PM25Revised: "0012", PM10Revised: "0020", VOCIndex: "0035", NO2Index: "0010", HCHORevised: "0042"},
+			want: EnvironmentValues{
+				PM25Revised: SensorReading{Value: 12, Present: true},
+				PM10Revised: SensorReading{Value: 20, Present: true},
+				VOCIndex:    SensorReading{Value: 3.5, Present: true},
+				NO2Index:    SensorReading{Value: 1, Present: true},
+				HCHORevised: SensorReading{Value: 0.042, Present: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.env.Values()
+			if got.Temperature != tt.want.Temperature || got.Humidity != tt.want.Humidity || got.Particle != tt.want.Particle ||
+				got.VOC != tt.want.VOC || got.SleepTimer != tt.want.SleepTimer {
+				t.Errorf("Values() = %+v, want %+v", *got, tt.want)
+			}
+			sensors := []struct {
+				name string
+				got  SensorReading
+				want SensorReading
+			}{
+				{"pm25", got.PM25, tt.want.PM25},
+				{"pm10", got.PM10, tt.want.PM10},
+				{"p25r", got.PM25Revised, tt.want.PM25Revised},
+				{"p10r", got.PM10Revised, tt.want.PM10Revised},
+				{"va10", got.VOCIndex, tt.want.VOCIndex},
+				{"noxl", got.NO2Index, tt.want.NO2Index},
+				{"hcho", got.HCHO, tt.want.HCHO},
+				{"hchr", got.HCHORevised, tt.want.HCHORevised},
+			}
+			for _, s := range sensors {
+				if s.got.Present != s.want.Present || s.got.Initializing != s.want.Initializing || math.Abs(s.got.Value-s.want.Value) > 1e-9 {
+					t.Errorf("%s = %+v, want %+v", s.name, s.got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSensor(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale float64
+		want  SensorReading
+	}{
+		{"", 1, SensorReading{}},
+		{"OFF", 1, SensorReading{}},
+		{"NONE", 1, SensorReading{}},
+		{"garbage", 1, SensorReading{}},
+		{"INIT", 1, SensorReading{Present: true, Initializing: true}},
+		{"0000", 1, SensorReading{Present: true}},
+		{"0012", 1, SensorReading{Value: 12, Present: true}},
+		{"0035", 0.1, SensorReading{Value: 3.5, Present: true}},
+		{"0042", 0.001, SensorReading{Value: 0.042, Present: true}},
+	}
+
+	for _, tt := range tests {
+		got := parseSensor(tt.in, tt.scale)
+		if got.Present != tt.want.Present || got.Initializing != tt.want.Initializing || math.Abs(got.Value-tt.want.Value) > 1e-9 {
+			t.Errorf("parseSensor(%q, %v) = %+v, want %+v", tt.in, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeEnvSensorData(t *testing.T) {
+	payload := `{"msg":"ENVIRONMENTAL-CURRENT-SENSOR-DATA","time":"2019-03-17T12:00:00.000Z","data":{` +
+		`"tact":"2950","hact":"0045","pact":"0003","vact":"0002","sltm":"0030",` +
+		`"pm25":"0012","pm10":"0020","p25r":"INIT","va10":"0035","noxl":"OFF","hcho":"0042"}}`
+
+	ev := decodeMessage([]byte(payload))
+	if ev.Kind != EventEnvironment || ev.Error != nil {
+		t.Fatalf("decodeMessage() = %+v, want an environment event", ev)
+	}
+	env, ok := ev.Environment()
+	if !ok || env == nil {
+		t.Fatalf("Environment() = %v, %v", env, ok)
+	}
+
+	v := env.Values()
+	if v.Temperature != 2950 || v.Humidity != 45 || v.Particle != 3 || v.VOC != 2 || v.SleepTimer.Minutes() != 30 {
+		t.Errorf("Values() = %+v", v)
+	}
+	sensors := []struct {
+		name string
+		got  SensorReading
+		want SensorReading
+	}{
+		{"pm25", v.PM25, SensorReading{Value: 12, Present: true}},
+		{"pm10", v.PM10, SensorReading{Value: 20, Present: true}},
+		{"p25r", v.PM25Revised, SensorReading{Present: true, Initializing: true}},
+		{"p10r", v.PM10Revised, SensorReading{}},
+		{"va10", v.VOCIndex, SensorReading{Value: 3.5, Present: true}},
+		{"noxl", v.NO2Index, SensorReading{}},
+		{"hcho", v.HCHO, SensorReading{Value: 0.042, Present: true}},
+	}
+	for _, s := range sensors {
+		if s.got.Present != s.want.Present || s.got.Initializing != s.want.Initializing || math.Abs(s.got.Value-s.want.Value) > 1e-9 {
+			t.Errorf("%s = %+v, want %+v", s.name, s.got, s.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2016 Adrian Ulrich
+ *
+ * All rights reserved. This program and the accompanying materials
+ * are made available under the terms of the Eclipse Public License v1.0
+ * which accompanies this distribution, and is available at
+ * http://www.eclipse.org/legal/epl-v10.html
+ *
+ */
+
+package dyslink
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultReplyTimeout is the time we wait for a reply of the device
+// if the passed context has no deadline.
+const DefaultReplyTimeout = 10 * time.Second
+
+// TimeoutError is returned if the device did not send an
+// expected message in time.
+type TimeoutError struct {
+	Command string // The message we were waiting for
+	Err     error  // The error of the expired context
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out waiting for %s: %v", e.Command, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// A waiter receives copies of decoded messages with a
+// specific command while it is registered
+type waiter struct {
+	command string
+	ch      chan interface{}
+}
+
+// addWaiter registers a new waiter for given command.
+// Callers must call removeWaiter once done.
+func (c *client) addWaiter(command string) *waiter {
+	w := &waiter{command: command, ch: make(chan interface{}, 8)}
+	c.mu.Lock()
+	c.waiters[w] = true
+	c.mu.Unlock()
+	return w
+}
+
+// removeWaiter unregisters a waiter added via addWaiter
+func (c *client) removeWaiter(w *waiter) {
+	c.mu.Lock()
+	delete(c.waiters, w)
+	c.mu.Unlock()
+}
+
+// notifyWaiters hands a decoded message to all interested waiters.
+// Waiters which are not keeping up will miss messages.
+func (c *client) notifyWaiters(command string, msg interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for w := range c.waiters {
+		if w.command != command {
+			continue
+		}
+		select {
+		case w.ch <- msg:
+		default:
+		}
+	}
+}
+
+// replyContext returns a context which expires after DefaultReplyTimeout
+// if the parent context has no deadline.
+func replyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, DefaultReplyTimeout)
+}